@@ -0,0 +1,179 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/giulioborghesi/mapreduce/master"
+)
+
+// readFields reads a configuration file and returns the whitespace separated
+// fields of each line. Empty lines and lines starting with '#' are ignored.
+// Lines with a number of fields different from cnt are reported as errors,
+// unless cnt is zero
+func readFields(path string, cnt int) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	res := [][]string{}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if cnt > 0 && len(fields) != cnt {
+			return nil, fmt.Errorf("readfields: %s:%d: expected %d fields, "+
+				"got %d", path, lineNo, cnt, len(fields))
+		}
+		res = append(res, fields)
+	}
+	return res, scanner.Err()
+}
+
+// LoadTopology loads a topology file that maps hosts to racks. Each line of
+// the file contains a host name and the name of its rack
+func LoadTopology(path string) (map[string]string, error) {
+	lines, err := readFields(path, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]string)
+	for _, fields := range lines {
+		res[fields[0]] = fields[1]
+	}
+	return res, nil
+}
+
+// LoadLocationHints loads a file that maps the index of each map task to the
+// hosts storing its input. Each line of the file contains a map task index
+// and a comma separated list of hosts
+func LoadLocationHints(path string) (map[int][]string, error) {
+	lines, err := readFields(path, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[int][]string)
+	for _, fields := range lines {
+		idx, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("loadlocationhints: invalid map task "+
+				"index: %s", fields[0])
+		}
+		res[idx] = strings.Split(fields[1], ",")
+	}
+	return res, nil
+}
+
+// LoadSchedulerConfig loads a file describing how the workers slots are
+// shared among concurrent jobs. The file contains the following lines, all
+// optional:
+//
+//	policy fifo|fair|capacity [job|pool]
+//	pool <name> <weight or capacity>
+//	preemption_timeout <duration>
+//
+// The optional second field of the policy line selects whether the fair
+// policy shares slots equally among jobs or among pools
+func LoadSchedulerConfig(path string) (master.SchedulerConfig, error) {
+	cfg := master.SchedulerConfig{Pools: make(map[string]float64)}
+	lines, err := readFields(path, 0)
+	if err != nil {
+		return cfg, err
+	}
+
+	for _, fields := range lines {
+		switch {
+		case fields[0] == "policy" && (len(fields) == 2 || len(fields) == 3):
+			cfg.Policy = fields[1]
+			if cfg.Policy != master.FIFOPolicy &&
+				cfg.Policy != master.FairPolicy &&
+				cfg.Policy != master.CapacityPolicy {
+				return cfg, fmt.Errorf("loadschedulerconfig: unknown "+
+					"policy: %s", cfg.Policy)
+			}
+			if len(fields) == 3 {
+				if fields[2] != "job" && fields[2] != "pool" {
+					return cfg, fmt.Errorf("loadschedulerconfig: invalid "+
+						"sharing mode: %s", fields[2])
+				}
+				cfg.PerJob = fields[2] == "job"
+			}
+		case fields[0] == "pool" && len(fields) == 3:
+			weight, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil || weight < 0 {
+				return cfg, fmt.Errorf("loadschedulerconfig: invalid "+
+					"weight for pool %s: %s", fields[1], fields[2])
+			}
+			cfg.Pools[fields[1]] = weight
+		case fields[0] == "preemption_timeout" && len(fields) == 2:
+			timeout, err := time.ParseDuration(fields[1])
+			if err != nil {
+				return cfg, fmt.Errorf("loadschedulerconfig: invalid "+
+					"preemption timeout: %s", fields[1])
+			}
+			cfg.PreemptionTimeout = timeout
+		default:
+			return cfg, fmt.Errorf("loadschedulerconfig: invalid line: %s",
+				strings.Join(fields, " "))
+		}
+	}
+	return cfg, nil
+}
+
+// ParseTaggedInputs parses a comma separated list of tagged inputs of the
+// form tag[:format]=path. Paths with the same tag belong to the same tagged
+// input, and the input format of a tag is the job input format if omitted
+func ParseTaggedInputs(s string) ([]master.TaggedInput, error) {
+	res := make([]master.TaggedInput, 0)
+	pos := make(map[string]int)
+	for _, item := range strings.Split(s, ",") {
+		tag, path, ok := strings.Cut(item, "=")
+		if !ok || tag == "" || path == "" {
+			return nil, fmt.Errorf("parsetaggedinputs: invalid tagged "+
+				"input: %s", item)
+		}
+		tag, format, _ := strings.Cut(tag, ":")
+
+		idx, ok := pos[tag]
+		if !ok {
+			idx = len(res)
+			pos[tag] = idx
+			res = append(res, master.TaggedInput{Tag: tag,
+				InputFormat: format})
+		}
+		if format != "" && format != res[idx].InputFormat {
+			return nil, fmt.Errorf("parsetaggedinputs: conflicting input "+
+				"formats for tag %s", tag)
+		}
+		res[idx].Inputs = append(res[idx].Inputs, path)
+	}
+	return res, nil
+}
+
+// ParseNamedOutputs parses a comma separated list of named outputs of the
+// form name[:format], and returns the output format of each named output.
+// The output format is the job output format if omitted
+func ParseNamedOutputs(s string) (map[string]string, error) {
+	res := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		name, format, _ := strings.Cut(item, ":")
+		if _, ok := res[name]; ok || name == "" {
+			return nil, fmt.Errorf("parsenamedoutputs: missing or duplicate "+
+				"named output: %q", name)
+		}
+		res[name] = format
+	}
+	return res, nil
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"fmt"
+	"net/rpc"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/giulioborghesi/mapreduce/master"
+	"github.com/giulioborghesi/mapreduce/workers"
+)
+
+const (
+	// submitJob is the service method to be used for submitting a job
+	submitJob = "MasterService.Submit"
+	// jobStatus is the service method to be used for retrieving a job status
+	jobStatus = "MasterService.JobStatus"
+	// listJobs is the service method to be used for listing jobs
+	listJobs = "MasterService.ListJobs"
+	// pollTimeInMs is the time between two job status requests when waiting
+	// for a job to complete
+	pollTimeInMs = 1000
+)
+
+// SubmitJob submits a job to the master running at the specified address and
+// returns the job ID. If wait is true, the function waits for the job to
+// complete and prints its final status
+func SubmitJob(addr string, spec master.JobSpec, wait bool) (string, error) {
+	client, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	var id string
+	if err := client.Call(submitJob, spec, &id); err != nil {
+		return "", err
+	}
+	fmt.Println("Job submitted:", id)
+
+	for wait {
+		var status master.JobStatus
+		if err := client.Call(jobStatus, id, &status); err != nil {
+			return id, err
+		}
+
+		if status.State != "queued" && status.State != "running" {
+			printJobStatus(status)
+			break
+		}
+		time.Sleep(pollTimeInMs * time.Millisecond)
+	}
+	return id, nil
+}
+
+// PrintJobStatus prints the status of the job with the specified ID, or of
+// all the jobs known to the master if id is empty
+func PrintJobStatus(addr string, id string) error {
+	client, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if id != "" {
+		var status master.JobStatus
+		if err := client.Call(jobStatus, id, &status); err != nil {
+			return err
+		}
+		printJobStatus(status)
+		return nil
+	}
+
+	var statuses []master.JobStatus
+	if err := client.Call(listJobs, workers.Void{}, &statuses); err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", status.ID, status.State,
+			status.Pool, status.Name, status.Summary)
+	}
+	return nil
+}
+
+// printJobStatus prints a job status, including its counters and the last
+// lines of the log of its last failed attempt
+func printJobStatus(status master.JobStatus) {
+	fmt.Printf("Job %s (%s, pool %s): %s\n", status.ID, status.Name,
+		status.Pool, status.State)
+	if status.Error != "" {
+		fmt.Println("  Error:", status.Error)
+	}
+	if f := status.LastFailure; f != nil {
+		fmt.Printf("  Last failed attempt: %s on %s: %s\n", f.AttemptID,
+			f.Worker, f.Error)
+		fmt.Println("  Log:", f.LogURL)
+		if f.LogTail != "" {
+			for _, line := range strings.Split(f.LogTail, "\n") {
+				fmt.Println("    " + line)
+			}
+		}
+	}
+	if status.Summary != "" {
+		fmt.Println("  Progress:", status.Summary)
+	}
+	if !status.Finished.IsZero() {
+		fmt.Println("  Duration:", status.Finished.Sub(status.Started))
+	}
+
+	names := make([]string, 0, len(status.Counters))
+	for name := range status.Counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s: %d\n", name, status.Counters[name])
+	}
+}
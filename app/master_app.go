@@ -1,8 +1,11 @@
 package app
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
+	"net/http"
+	"net/rpc"
 	"time"
 
 	"github.com/giulioborghesi/mapreduce/master"
@@ -22,7 +25,7 @@ func findActiveWorkers(addrs []string) []string {
 	res := make([]string, 0, len(addrs))
 
 	for i := 1; i <= retries; i++ {
-		log.Println("Contacting workers, attempt: ", i)
+		slog.Info("contacting workers", "attempt", i)
 		n := len(addrs)
 		for j := n - 1; j >= 0; j-- {
 			conn, err := net.Dial("tcp", addrs[j])
@@ -40,23 +43,120 @@ func findActiveWorkers(addrs []string) []string {
 		}
 
 		wait := time.Duration(fact) * time.Millisecond
-		log.Println("Not all workers available, waiting for ", wait,
-			" before retrying")
+		slog.Info("not all workers available, retrying", "wait", wait,
+			"missing", addrs)
 		time.Sleep(wait)
 		fact *= 2
 	}
 	return res
 }
 
-// StartMaster initializes the MapReduce master and starts the MapReduce
-// computation
-func StartMaster(addrs []string, filePath string, reducerCnt int) {
+// serveCache serves the side files of the jobs managed by a coordinator on
+// the coordinator cache address
+func serveCache(c *master.Coordinator, addr string) error {
+	http.HandleFunc(master.CachePath, c.ServeCache)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("servecache: %v", err)
+	}
+	go http.Serve(l, nil)
+	return nil
+}
+
+// makeCoordinator creates a coordinator managing the workers that can be
+// contacted among the specified ones
+func makeCoordinator(addrs []string, cfg master.Config) (*master.Coordinator,
+	error) {
 	addrs = findActiveWorkers(addrs)
 	if len(addrs) == 0 {
-		log.Println("No worker available, terminating program...")
-		return
+		return nil, fmt.Errorf("makecoordinator: no worker available")
 	}
+	return master.MakeCoordinator(addrs, cfg)
+}
 
-	c := master.MakeCoordinator(addrs, filePath, 1, reducerCnt)
+// StartMaster initializes the MapReduce master and runs a single MapReduce
+// job to completion. Side files are only served if the job has any. An error
+// is returned if the job cannot be run or fails
+func StartMaster(addrs []string, spec master.JobSpec,
+	cfg master.Config) error {
+	c, err := makeCoordinator(addrs, cfg)
+	if err != nil {
+		return err
+	}
+	if len(spec.CacheFiles) > 0 {
+		if err := serveCache(c, cfg.CacheAddress); err != nil {
+			return err
+		}
+	}
+	id, err := c.Submit(spec)
+	if err != nil {
+		return err
+	}
+
+	go c.Run()
+	status, _ := c.Wait(id)
+	c.Stop()
+	printJobStatus(status)
+	if status.Error != "" {
+		return fmt.Errorf("startmaster: job %s failed: %s", id, status.Error)
+	}
+	return nil
+}
+
+// StartMasterDaemon initializes a long-running MapReduce master that accepts
+// job submissions on the specified address and executes them on the workers.
+// Side files are served on the same address. An error is returned if the
+// master cannot be started
+func StartMasterDaemon(addr string, addrs []string, cfg master.Config) error {
+	// Register master service and side files endpoints
+	cfg.CacheAddress = addr
+	c, err := makeCoordinator(addrs, cfg)
+	if err != nil {
+		return err
+	}
+	rpc.Register(master.MakeMasterService(c))
+	rpc.HandleHTTP()
+	http.HandleFunc(master.CachePath, c.ServeCache)
+
+	// Create listener and serve incoming requests while running jobs
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("startmasterdaemon: %v", err)
+	}
+	go http.Serve(l, nil)
+	slog.Info("master started", "address", addr)
 	c.Run()
+	return nil
+}
+
+// RunWorkflow initializes the MapReduce master and runs a single workflow to
+// completion. An error is returned if the workflow cannot be run or fails
+func RunWorkflow(addrs []string, spec master.WorkflowSpec,
+	cfg master.Config) error {
+	c, err := makeCoordinator(addrs, cfg)
+	if err != nil {
+		return err
+	}
+	for _, step := range spec.Steps {
+		if len(step.Job.CacheFiles) > 0 {
+			if err := serveCache(c, cfg.CacheAddress); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	id, err := c.SubmitWorkflow(spec)
+	if err != nil {
+		return err
+	}
+
+	go c.Run()
+	status, _ := c.WaitWorkflow(id)
+	c.Stop()
+	printWorkflowStatus(status)
+	if status.Error != "" {
+		return fmt.Errorf("runworkflow: workflow %s failed: %s", id,
+			status.Error)
+	}
+	return nil
 }
@@ -1,7 +1,8 @@
 package app
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/rpc"
@@ -10,26 +11,30 @@ import (
 	"github.com/giulioborghesi/mapreduce/workers"
 )
 
-// StartWorker starts a MapReduce RPC worker
-func StartWorker(addr string) {
+// StartWorker starts a MapReduce RPC worker with the specified configuration
+// and serves requests until an error occurs
+func StartWorker(addr string, cfg workers.Config) error {
 	// Extract port number from address string
 	port, err := utils.GetPort(addr)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("startworker: %v", err)
 	}
 
 	// Register MapReduce service endpoints
-	service := workers.MakeMapReduceService()
+	cfg.Address = addr
+	service := workers.MakeMapReduceService(cfg)
 	rpc.Register(service)
 	rpc.HandleHTTP()
 
-	// Register HTTP endpoint for data transfer
+	// Register HTTP endpoints for data transfer and task logs
 	http.HandleFunc("/data/", workers.SendData)
+	http.HandleFunc("/logs/", workers.SendLog)
 
 	// Create listener and serve incoming requests
 	l, err := net.Listen("tcp", ":"+port)
 	if err != nil {
-		log.Fatal("startworker:", err)
+		return fmt.Errorf("startworker: %v", err)
 	}
-	http.Serve(l, nil)
+	slog.Info("worker started", "worker", addr)
+	return fmt.Errorf("startworker: %v", http.Serve(l, nil))
 }
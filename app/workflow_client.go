@@ -0,0 +1,118 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/rpc"
+	"os"
+	"time"
+
+	"github.com/giulioborghesi/mapreduce/master"
+)
+
+const (
+	// submitWorkflow is the service method to be used for submitting a
+	// workflow
+	submitWorkflow = "MasterService.SubmitWorkflow"
+	// resumeWorkflow is the service method to be used for resuming a failed
+	// workflow
+	resumeWorkflow = "MasterService.ResumeWorkflow"
+	// workflowStatus is the service method to be used for retrieving a
+	// workflow status
+	workflowStatus = "MasterService.WorkflowStatus"
+)
+
+// LoadWorkflow loads a workflow specification from a JSON file. Keys are
+// matched to the fields of master.WorkflowSpec, master.WorkflowStep and
+// master.JobSpec, for example:
+//
+//	{"Name": "wordcount", "Dir": "/tmp/wordcount", "Steps": [
+//	  {"Name": "count", "Job": {"Inputs": ["/tmp/a.dat"], "Reducers": 2}},
+//	  {"Name": "recount", "Job": {"Reducers": 1}, "DependsOn": ["count"]}]}
+func LoadWorkflow(path string) (master.WorkflowSpec, error) {
+	var spec master.WorkflowSpec
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return spec, err
+	}
+
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return spec, fmt.Errorf("loadworkflow: %s: %v", path, err)
+	}
+	return spec, nil
+}
+
+// SubmitWorkflow submits a workflow to the master running at the specified
+// address and returns the workflow ID. If wait is true, the function waits
+// for the workflow to complete and prints its final status
+func SubmitWorkflow(addr string, spec master.WorkflowSpec,
+	wait bool) (string, error) {
+	return callWorkflow(addr, submitWorkflow, spec, wait)
+}
+
+// ResumeWorkflow resumes a failed workflow on the master running at the
+// specified address and returns the ID of the resumed workflow. If wait is
+// true, the function waits for the workflow to complete and prints its final
+// status
+func ResumeWorkflow(addr string, id string, wait bool) (string, error) {
+	return callWorkflow(addr, resumeWorkflow, id, wait)
+}
+
+// callWorkflow invokes a master method that starts a workflow and optionally
+// waits for the workflow to complete
+func callWorkflow(addr string, method string, args interface{},
+	wait bool) (string, error) {
+	client, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	var id string
+	if err := client.Call(method, args, &id); err != nil {
+		return "", err
+	}
+	fmt.Println("Workflow started:", id)
+
+	for wait {
+		var status master.WorkflowStatus
+		if err := client.Call(workflowStatus, id, &status); err != nil {
+			return id, err
+		}
+
+		if status.State != "running" {
+			printWorkflowStatus(status)
+			break
+		}
+		time.Sleep(pollTimeInMs * time.Millisecond)
+	}
+	return id, nil
+}
+
+// PrintWorkflowStatus prints the status of the workflow with the specified ID
+func PrintWorkflowStatus(addr string, id string) error {
+	client, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var status master.WorkflowStatus
+	if err := client.Call(workflowStatus, id, &status); err != nil {
+		return err
+	}
+	printWorkflowStatus(status)
+	return nil
+}
+
+// printWorkflowStatus prints a workflow status, including the status of its
+// steps
+func printWorkflowStatus(status master.WorkflowStatus) {
+	fmt.Printf("Workflow %s (%s): %s\n", status.ID, status.Name, status.State)
+	if status.Error != "" {
+		fmt.Println("  Error:", status.Error)
+	}
+	for _, st := range status.Steps {
+		fmt.Printf("  %s: %s %s\n", st.Name, st.State, st.JobID)
+	}
+}
@@ -0,0 +1,23 @@
+package common
+
+import (
+	"io"
+	"log/slog"
+)
+
+// LogConfig configures the logs of the master and of the workers. Records
+// below Level are discarded, and records are written as JSON objects if JSON
+// is true, or as key=value pairs otherwise
+type LogConfig struct {
+	Level slog.Level
+	JSON  bool
+}
+
+// Handler returns a log handler writing the records to w
+func (cfg LogConfig) Handler(w io.Writer) slog.Handler {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	if cfg.JSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
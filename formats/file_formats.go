@@ -0,0 +1,134 @@
+package formats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	// CSVFormat is the name of the input format of CSV files with headers
+	CSVFormat = "csv"
+	// WholeFileFormat is the name of the input format that reads each file
+	// as a single record
+	WholeFileFormat = "whole_file"
+)
+
+// CSVInputFormat reads CSV files whose first row is a header naming the
+// columns. Each of the other rows is a record, whose key is the row number,
+// starting from one, and whose fields are indexed by column name. The value
+// of a record is the JSON encoding of its fields. CSV files are not split,
+// since quoted fields can contain newlines
+type CSVInputFormat struct{}
+
+// Splits returns a single split for a CSV file
+func (CSVInputFormat) Splits(path string, _ int64) ([]Split, error) {
+	return fileSplits(path, 0, false)
+}
+
+// Reader returns a reader of the rows of a CSV file
+func (CSVInputFormat) Reader(split Split) (RecordReader, error) {
+	f, err := os.Open(split.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err == io.EOF {
+		header, err = nil, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &csvReader{f: f, r: r, header: header}, nil
+}
+
+// csvReader reads the rows of a CSV file with headers
+type csvReader struct {
+	f      *os.File
+	r      *csv.Reader
+	header []string
+	row    int
+}
+
+// Next returns the next row of the file
+func (r *csvReader) Next() (Record, error) {
+	if r.header == nil {
+		return Record{}, io.EOF
+	}
+	fields, err := r.r.Read()
+	if err != nil {
+		return Record{}, err
+	}
+
+	r.row++
+	rec := Record{Key: strconv.Itoa(r.row),
+		Fields: make(map[string]string, len(fields))}
+	for idx, field := range fields {
+		rec.Fields[r.header[idx]] = field
+	}
+	value, err := json.Marshal(rec.Fields)
+	if err != nil {
+		return Record{}, err
+	}
+	rec.Value = string(value)
+	return rec, nil
+}
+
+// BytesRead returns the number of bytes of the file read so far
+func (r *csvReader) BytesRead() int64 {
+	return r.r.InputOffset()
+}
+
+// Close closes the file read by the reader
+func (r *csvReader) Close() error {
+	return r.f.Close()
+}
+
+// WholeFileInputFormat reads each file as a single record, whose key is the
+// path of the file and whose value is the file content
+type WholeFileInputFormat struct{}
+
+// Splits returns a single split for a file
+func (WholeFileInputFormat) Splits(path string, _ int64) ([]Split, error) {
+	return fileSplits(path, 0, false)
+}
+
+// Reader returns a reader of the file content
+func (WholeFileInputFormat) Reader(split Split) (RecordReader, error) {
+	return &wholeFileReader{path: split.Path}, nil
+}
+
+// wholeFileReader reads a file as a single record
+type wholeFileReader struct {
+	path string
+	read int64
+	done bool
+}
+
+// Next returns the file content on the first call, and io.EOF afterwards
+func (r *wholeFileReader) Next() (Record, error) {
+	if r.done {
+		return Record{}, io.EOF
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return Record{}, err
+	}
+	r.done, r.read = true, int64(len(data))
+	return Record{Key: r.path, Value: string(data)}, nil
+}
+
+// BytesRead returns the size of the file once it has been read
+func (r *wholeFileReader) BytesRead() int64 {
+	return r.read
+}
+
+// Close does nothing, since the file is closed once read
+func (r *wholeFileReader) Close() error {
+	return nil
+}
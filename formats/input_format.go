@@ -0,0 +1,122 @@
+package formats
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	// DefaultInputFormat is the input format of jobs that do not specify one
+	DefaultInputFormat = TextFormat
+	// DefaultSplitSize is the maximum size of the input splits of jobs that
+	// do not specify one
+	DefaultSplitSize = 64 << 20
+)
+
+// Split describes the portion of an input file processed by a Map task. The
+// split starts at byte Offset of the file and is Length bytes long
+type Split struct {
+	Path           string
+	Offset, Length int64
+}
+
+// Record is an input record passed to the Map function. The meaning of Key
+// and Value depends on the input format, while Fields holds the named fields
+// of the record for input formats that support them. Source is the tag of
+// the job input the record was read from, empty for untagged inputs
+type Record struct {
+	Key, Value string
+	Fields     map[string]string
+	Source     string
+}
+
+// RecordReader reads the records of a split. Next returns io.EOF once all
+// the records of the split have been read, while BytesRead returns the number
+// of bytes of the split read so far
+type RecordReader interface {
+	Next() (Record, error)
+	BytesRead() int64
+	Close() error
+}
+
+// InputFormat describes how input files are divided into splits and how
+// records are read from a split. Splits are computed by the master, while
+// records are read by the workers executing the Map tasks
+type InputFormat interface {
+	Splits(path string, splitSize int64) ([]Split, error)
+	Reader(split Split) (RecordReader, error)
+}
+
+var (
+	inputFormats = map[string]InputFormat{
+		TextFormat:      TextInputFormat{},
+		KeyValueFormat:  KeyValueInputFormat{},
+		JSONFormat:      JSONInputFormat{},
+		CSVFormat:       CSVInputFormat{},
+		WholeFileFormat: WholeFileInputFormat{},
+		SequenceFormat:  SequenceFileInputFormat{},
+	}
+	inputFormatsMu sync.Mutex
+)
+
+// RegisterInputFormat makes an input format available to jobs under the
+// specified name. This function will panic if an input format with the same
+// name is already registered
+func RegisterInputFormat(name string, f InputFormat) {
+	inputFormatsMu.Lock()
+	defer inputFormatsMu.Unlock()
+
+	if _, ok := inputFormats[name]; ok {
+		panic(fmt.Sprintf("registerinputformat: input format %s already "+
+			"registered", name))
+	}
+	inputFormats[name] = f
+}
+
+// LookupInputFormat returns the input format registered under the specified
+// name. The default input format is returned if name is empty
+func LookupInputFormat(name string) (InputFormat, error) {
+	inputFormatsMu.Lock()
+	defer inputFormatsMu.Unlock()
+
+	if name == "" {
+		name = DefaultInputFormat
+	}
+	f, ok := inputFormats[name]
+	if !ok {
+		return nil, fmt.Errorf("lookupinputformat: unknown input format: %s",
+			name)
+	}
+	return f, nil
+}
+
+// fileSplits divides a file into splits of at most splitSize bytes. Files
+// that are not splittable, or whose size does not exceed splitSize, are
+// processed by a single split. A non-positive split size means that files
+// are not split
+func fileSplits(path string, splitSize int64, splittable bool) ([]Split,
+	error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("filesplits: %s is a directory", path)
+	}
+
+	size := info.Size()
+	if !splittable || splitSize <= 0 || size <= splitSize {
+		return []Split{{Path: path, Offset: 0, Length: size}}, nil
+	}
+
+	res := make([]Split, 0, (size+splitSize-1)/splitSize)
+	for offset := int64(0); offset < size; offset += splitSize {
+		length := splitSize
+		if offset+length > size {
+			length = size - offset
+		}
+		res = append(res, Split{Path: path, Offset: offset, Length: length})
+	}
+	return res, nil
+}
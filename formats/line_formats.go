@@ -0,0 +1,191 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TextFormat is the name of the input format of text files, whose records
+	// are lines
+	TextFormat = "text"
+	// KeyValueFormat is the name of the input format of text files whose
+	// lines are tab separated key / value pairs
+	KeyValueFormat = "tsv"
+	// JSONFormat is the name of the input format of text files whose lines
+	// are JSON documents
+	JSONFormat = "ndjson"
+)
+
+// lineReader reads the lines of a split of a text file. Lines belong to the
+// split where they start: a reader skips the line that starts before its
+// split, and reads the line that crosses the end of its split. The last line
+// of a file is read even if it is not terminated by a newline
+type lineReader struct {
+	f               *os.File
+	r               *bufio.Reader
+	start, pos, end int64
+}
+
+// openLineReader creates a new lineReader object for the specified split
+func openLineReader(split Split) (*lineReader, error) {
+	f, err := os.Open(split.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(split.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	lr := &lineReader{f: f, r: bufio.NewReader(f), start: split.Offset,
+		pos: split.Offset, end: split.Offset + split.Length}
+	if split.Offset > 0 {
+		// The line starting before the split belongs to the previous split
+		if _, err := lr.line(); err != nil && err != io.EOF {
+			f.Close()
+			return nil, err
+		}
+	}
+	return lr, nil
+}
+
+// line reads the next line and returns it without the line terminator
+func (lr *lineReader) line() (string, error) {
+	l, err := lr.r.ReadString('\n')
+	if err == io.EOF && len(l) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	lr.pos += int64(len(l))
+	l = strings.TrimSuffix(l, "\n")
+	return strings.TrimSuffix(l, "\r"), nil
+}
+
+// next returns the next line of the split and its offset in the file
+func (lr *lineReader) next() (int64, string, error) {
+	if lr.pos > lr.end {
+		return 0, "", io.EOF
+	}
+	offset := lr.pos
+	l, err := lr.line()
+	return offset, l, err
+}
+
+// BytesRead returns the number of bytes of the split read so far
+func (lr *lineReader) BytesRead() int64 {
+	if lr.pos > lr.end {
+		return lr.end - lr.start
+	}
+	return lr.pos - lr.start
+}
+
+// Close closes the file read by the reader
+func (lr *lineReader) Close() error {
+	return lr.f.Close()
+}
+
+// lineRecordReader reads the records of a split of a text file whose records
+// are lines. The parse function converts a line and its offset to a record;
+// lines for which it returns false are skipped
+type lineRecordReader struct {
+	*lineReader
+	parse func(offset int64, line string) (Record, bool, error)
+}
+
+// Next returns the next record of the split
+func (r *lineRecordReader) Next() (Record, error) {
+	for {
+		offset, l, err := r.next()
+		if err != nil {
+			return Record{}, err
+		}
+		rec, ok, err := r.parse(offset, l)
+		if err != nil || ok {
+			return rec, err
+		}
+	}
+}
+
+// readLines returns a reader of the records of a split of a text file, whose
+// lines are converted to records by parse
+func readLines(split Split, parse func(int64, string) (Record, bool,
+	error)) (RecordReader, error) {
+	lr, err := openLineReader(split)
+	if err != nil {
+		return nil, err
+	}
+	return &lineRecordReader{lineReader: lr, parse: parse}, nil
+}
+
+// TextInputFormat reads text files. Each line is a record, whose key is the
+// offset of the line in the file and whose value is the line
+type TextInputFormat struct{}
+
+// Splits divides a text file into splits
+func (TextInputFormat) Splits(path string, splitSize int64) ([]Split, error) {
+	return fileSplits(path, splitSize, true)
+}
+
+// Reader returns a reader of the lines of a split
+func (TextInputFormat) Reader(split Split) (RecordReader, error) {
+	return readLines(split, func(offset int64, l string) (Record, bool,
+		error) {
+		return Record{Key: strconv.FormatInt(offset, 10), Value: l}, true,
+			nil
+	})
+}
+
+// KeyValueInputFormat reads text files whose lines are key / value pairs.
+// Keys are separated from values by the first tab of the line; lines without
+// tabs are keys with an empty value
+type KeyValueInputFormat struct{}
+
+// Splits divides a text file into splits
+func (KeyValueInputFormat) Splits(path string, splitSize int64) ([]Split,
+	error) {
+	return fileSplits(path, splitSize, true)
+}
+
+// Reader returns a reader of the key / value pairs of a split
+func (KeyValueInputFormat) Reader(split Split) (RecordReader, error) {
+	return readLines(split, func(_ int64, l string) (Record, bool, error) {
+		key, value, _ := strings.Cut(l, "\t")
+		return Record{Key: key, Value: value}, true, nil
+	})
+}
+
+// JSONInputFormat reads text files whose lines are JSON documents. Each
+// document is a record, whose key is the offset of the document in the file
+// and whose value is the document. Empty lines are skipped
+type JSONInputFormat struct{}
+
+// Splits divides a text file into splits
+func (JSONInputFormat) Splits(path string, splitSize int64) ([]Split, error) {
+	return fileSplits(path, splitSize, true)
+}
+
+// Reader returns a reader of the JSON documents of a split. Reading fails on
+// lines that are not valid JSON documents
+func (JSONInputFormat) Reader(split Split) (RecordReader, error) {
+	return readLines(split, func(offset int64, l string) (Record, bool,
+		error) {
+		if strings.TrimSpace(l) == "" {
+			return Record{}, false, nil
+		}
+		if !json.Valid([]byte(l)) {
+			return Record{}, false, fmt.Errorf("reader: %s:%d: invalid JSON "+
+				"document", split.Path, offset)
+		}
+		return Record{Key: strconv.FormatInt(offset, 10), Value: l}, true,
+			nil
+	})
+}
@@ -0,0 +1,75 @@
+package formats
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readAll reads the values of all the records of the splits of a file
+func readAll(t *testing.T, f InputFormat, path string,
+	splitSize int64) []string {
+	splits, err := f.Splits(path, splitSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res := make([]string, 0)
+	for _, split := range splits {
+		rr, err := f.Reader(split)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for {
+			rec, err := rr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			res = append(res, rec.Value)
+		}
+		if rr.BytesRead() != split.Length {
+			t.Errorf("expected bytes read: %d, actual: %d", split.Length,
+				rr.BytesRead())
+		}
+		rr.Close()
+	}
+	return res
+}
+
+func TestTextInputFormatSplits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.txt")
+	data := "the quick\nbrown fox\r\n\njumps over\nthe lazy dog"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"the quick", "brown fox", "", "jumps over",
+		"the lazy dog"}
+	for splitSize := int64(1); splitSize <= int64(len(data)); splitSize++ {
+		res := readAll(t, TextInputFormat{}, path, splitSize)
+		if !reflect.DeepEqual(res, expected) {
+			t.Errorf("split size %d: expected: %q, actual: %q", splitSize,
+				expected, res)
+		}
+	}
+}
+
+func TestCSVInputFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.csv")
+	data := "name,city\nalice,\"New York, NY\"\nbob,Paris"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{`{"city":"New York, NY","name":"alice"}`,
+		`{"city":"Paris","name":"bob"}`}
+	res := readAll(t, CSVInputFormat{}, path, 1)
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected: %q, actual: %q", expected, res)
+	}
+}
@@ -0,0 +1,191 @@
+package formats
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SuccessMarker is the file written in the output directory of a job
+	// that completed successfully
+	SuccessMarker = "_SUCCESS"
+	// TemporaryDir is the directory, relative to the job output directory,
+	// where task attempts write their output before committing it
+	TemporaryDir = "_temporary"
+	// outputFilePrefix is the prefix of the names of the files written by
+	// the tasks of a job
+	outputFilePrefix = "part-"
+)
+
+// OutputCommitter implements the protocol that makes the output of a job
+// visible only once the job has completed. The master sets up the job output
+// when the job starts, clearing the partial output of a previous run first if
+// the job resumes a workflow step, and commits it once the output of all the tasks
+// producing the job output has been committed, or aborts it if the job
+// fails. Each task attempt writes its output to the directory returned by
+// SetupTask, and aborts it if the attempt fails. The output of successful
+// attempts is committed by the master, which commits the output of a single
+// attempt per task and aborts the others. All methods take the output
+// directory of the job
+type OutputCommitter interface {
+	SetupJob(dir string) error
+	ClearJob(dir string) error
+	CommitJob(dir string) error
+	AbortJob(dir string) error
+	SetupTask(dir, attemptID string) (string, error)
+	CommitTask(dir, attemptID string) error
+	AbortTask(dir, attemptID string) error
+}
+
+// FileOutputCommitter commits the output of task attempts by moving the files
+// written to an attempt-specific temporary directory to the job output
+// directory, and marks the output of successful jobs with a success marker
+type FileOutputCommitter struct{}
+
+// SetupJob creates the job output directory. Existing output is never
+// overwritten: directories holding complete output, or any entry other than
+// the temporary directory, are rejected
+func (FileOutputCommitter) SetupJob(dir string) error {
+	if OutputComplete(dir) {
+		return fmt.Errorf("setupjob: %s holds the output of a completed job",
+			dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() != TemporaryDir {
+			return fmt.Errorf("setupjob: %s is not empty: %s", dir,
+				entry.Name())
+		}
+	}
+	return os.MkdirAll(filepath.Join(dir, TemporaryDir), 0755)
+}
+
+// ClearJob removes the partial output left in the job output directory by a
+// previous run of the job that did not complete, such as a failed run of a
+// workflow step that is resumed. Complete output is never removed, and
+// directories holding files that were not written by a job are rejected
+func (FileOutputCommitter) ClearJob(dir string) error {
+	if OutputComplete(dir) {
+		return fmt.Errorf("clearjob: %s holds the output of a completed job",
+			dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if !partialOutputEntry(dir, entry) {
+			return fmt.Errorf("clearjob: %s is not the output of a job: %s",
+				dir, entry.Name())
+		}
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partialOutputEntry returns true if an entry of a directory can have been
+// written by a job that did not complete: the temporary directory, task
+// output files and named output directories holding task output files only
+func partialOutputEntry(dir string, entry os.DirEntry) bool {
+	name := entry.Name()
+	switch {
+	case name == TemporaryDir:
+		return true
+	case !entry.IsDir():
+		return strings.HasPrefix(name, outputFilePrefix)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, name))
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), outputFilePrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// CommitJob removes the temporary files left by the task attempts and writes
+// the success marker
+func (FileOutputCommitter) CommitJob(dir string) error {
+	os.RemoveAll(filepath.Join(dir, TemporaryDir))
+	f, err := os.Create(filepath.Join(dir, SuccessMarker))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// AbortJob removes the temporary files left by the task attempts
+func (FileOutputCommitter) AbortJob(dir string) error {
+	return os.RemoveAll(filepath.Join(dir, TemporaryDir))
+}
+
+// taskDir returns the temporary directory of a task attempt
+func taskDir(dir, attemptID string) string {
+	return filepath.Join(dir, TemporaryDir, attemptID)
+}
+
+// SetupTask creates the temporary directory of a task attempt and returns it
+func (FileOutputCommitter) SetupTask(dir, attemptID string) (string, error) {
+	path := taskDir(dir, attemptID)
+	return path, os.MkdirAll(path, 0755)
+}
+
+// CommitTask moves the files written by a task attempt to the job output
+// directory and removes the temporary directory of the attempt. Files in
+// subdirectories of the attempt directory, such as the files of named
+// outputs, are moved to the same subdirectories of the job output directory.
+// Attempts that wrote no file have nothing to commit
+func (c FileOutputCommitter) CommitTask(dir, attemptID string) error {
+	err := moveFiles(taskDir(dir, attemptID), dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.AbortTask(dir, attemptID)
+}
+
+// moveFiles moves the files of the src directory and of its subdirectories
+// to the dst directory, creating the subdirectories as needed
+func moveFiles(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		from := filepath.Join(src, entry.Name())
+		to := filepath.Join(dst, entry.Name())
+		if !entry.IsDir() {
+			err = os.Rename(from, to)
+		} else if err = os.MkdirAll(to, 0755); err == nil {
+			err = moveFiles(from, to)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AbortTask removes the temporary directory of a task attempt
+func (FileOutputCommitter) AbortTask(dir, attemptID string) error {
+	return os.RemoveAll(taskDir(dir, attemptID))
+}
+
+// OutputComplete returns true if the output directory of a job is marked as
+// complete
+func OutputComplete(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, SuccessMarker))
+	return err == nil
+}
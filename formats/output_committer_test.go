@@ -0,0 +1,119 @@
+package formats
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileOutputCommitter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	c := FileOutputCommitter{}
+	if err := c.SetupJob(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Two attempts of the same task write their output
+	for _, attemptID := range []string{"attempt_1_1", "attempt_1_2"} {
+		path, err := c.SetupTask(dir, attemptID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = os.WriteFile(filepath.Join(path, "part-r-00000"),
+			[]byte(attemptID), 0644)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if OutputComplete(dir) {
+		t.Errorf("output complete before the job is committed")
+	}
+
+	// Only the output of the committed attempt is visible
+	if err := c.AbortTask(dir, "attempt_1_1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.CommitTask(dir, "attempt_1_2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.CommitJob(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expected := []string{SuccessMarker, "part-r-00000"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected: %v, actual: %v", expected, names)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "part-r-00000"))
+	if string(data) != "attempt_1_2" {
+		t.Errorf("expected: attempt_1_2, actual: %s", data)
+	}
+}
+
+// writeFiles creates empty files at the specified paths relative to dir
+func writeFiles(t *testing.T, dir string, paths ...string) {
+	for _, path := range paths {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestFileOutputCommitterSetupJob(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	writeFiles(t, dir, "part-r-00000", "rejected/part-r-00001",
+		SuccessMarker)
+
+	// Complete output is neither overwritten nor cleared
+	c := FileOutputCommitter{}
+	if err := c.SetupJob(dir); err == nil {
+		t.Errorf("expected error for complete output")
+	}
+	if err := c.ClearJob(dir); err == nil {
+		t.Errorf("expected error for complete output")
+	}
+	if !OutputComplete(dir) {
+		t.Fatalf("complete output removed")
+	}
+
+	// Partial output is only removed when cleared explicitly
+	os.Remove(filepath.Join(dir, SuccessMarker))
+	writeFiles(t, dir, TemporaryDir+"/attempt_1_1/part-r-00001")
+	if err := c.SetupJob(dir); err == nil {
+		t.Errorf("expected error for partial output")
+	}
+	if err := c.ClearJob(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.SetupJob(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != TemporaryDir {
+		t.Errorf("partial output not removed: %v", entries)
+	}
+
+	// Directories holding other files are rejected and left untouched
+	writeFiles(t, dir, "part-r-00000", "notes.txt")
+	if err := c.ClearJob(dir); err == nil {
+		t.Errorf("expected error for non-job output")
+	}
+	for _, name := range []string{"part-r-00000", "notes.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
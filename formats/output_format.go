@@ -0,0 +1,173 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+const (
+	// DefaultOutputFormat is the output format of jobs that do not specify
+	// one
+	DefaultOutputFormat = TextFormat
+	// JSONLinesFormat is the name of the output format that writes each
+	// record as a JSON document on its own line
+	JSONLinesFormat = "jsonl"
+	// BinaryFormat is the name of the output format that writes records in
+	// the binary key / value format of the intermediate files
+	BinaryFormat = "binary"
+)
+
+// RecordWriter writes the output records of a task. Close flushes the records
+// buffered by the writer, but does not close the underlying writer
+type RecordWriter interface {
+	Write(key, value string) error
+	Close() error
+}
+
+// OutputFormat describes how the output records of a job are written. Writer
+// returns a writer of records to w, while Committer returns the committer of
+// the job output
+type OutputFormat interface {
+	Writer(w io.Writer) RecordWriter
+	Committer() OutputCommitter
+}
+
+var (
+	outputFormats = map[string]OutputFormat{
+		TextFormat:      TextOutputFormat{},
+		KeyValueFormat:  KeyValueOutputFormat{},
+		JSONLinesFormat: JSONLinesOutputFormat{},
+		BinaryFormat:    BinaryOutputFormat{},
+		SequenceFormat:  SequenceFileOutputFormat{Codec: "gzip"},
+	}
+	outputFormatsMu sync.Mutex
+)
+
+// RegisterOutputFormat makes an output format available to jobs under the
+// specified name. This function will panic if an output format with the same
+// name is already registered
+func RegisterOutputFormat(name string, f OutputFormat) {
+	outputFormatsMu.Lock()
+	defer outputFormatsMu.Unlock()
+
+	if _, ok := outputFormats[name]; ok {
+		panic(fmt.Sprintf("registeroutputformat: output format %s already "+
+			"registered", name))
+	}
+	outputFormats[name] = f
+}
+
+// LookupOutputFormat returns the output format registered under the specified
+// name. The default output format is returned if name is empty
+func LookupOutputFormat(name string) (OutputFormat, error) {
+	outputFormatsMu.Lock()
+	defer outputFormatsMu.Unlock()
+
+	if name == "" {
+		name = DefaultOutputFormat
+	}
+	f, ok := outputFormats[name]
+	if !ok {
+		return nil, fmt.Errorf("lookupoutputformat: unknown output format: %s",
+			name)
+	}
+	return f, nil
+}
+
+// bufferedWriter is a record writer that buffers its output and writes each
+// record using write
+type bufferedWriter struct {
+	w     *bufio.Writer
+	write func(w *bufio.Writer, key, value string) error
+}
+
+// Write writes a record
+func (bw *bufferedWriter) Write(key, value string) error {
+	return bw.write(bw.w, key, value)
+}
+
+// Close flushes the buffered records
+func (bw *bufferedWriter) Close() error {
+	return bw.w.Flush()
+}
+
+// writeText returns a function that writes records as lines of text, with
+// keys and values separated by sep
+func writeText(sep string) func(*bufio.Writer, string, string) error {
+	return func(w *bufio.Writer, key, value string) error {
+		_, err := w.WriteString(key + sep + value + "\n")
+		return err
+	}
+}
+
+// TextOutputFormat writes each record on its own line, with key and value
+// separated by a space
+type TextOutputFormat struct{}
+
+// Writer returns a writer of records as lines of text
+func (TextOutputFormat) Writer(w io.Writer) RecordWriter {
+	return &bufferedWriter{w: bufio.NewWriter(w), write: writeText(" ")}
+}
+
+// Committer returns the committer of the job output
+func (TextOutputFormat) Committer() OutputCommitter {
+	return FileOutputCommitter{}
+}
+
+// KeyValueOutputFormat writes each record on its own line, with key and value
+// separated by a tab. Its output can be read with KeyValueInputFormat
+type KeyValueOutputFormat struct{}
+
+// Writer returns a writer of records as lines of tab separated values
+func (KeyValueOutputFormat) Writer(w io.Writer) RecordWriter {
+	return &bufferedWriter{w: bufio.NewWriter(w), write: writeText("\t")}
+}
+
+// Committer returns the committer of the job output
+func (KeyValueOutputFormat) Committer() OutputCommitter {
+	return FileOutputCommitter{}
+}
+
+// JSONLinesOutputFormat writes each record as a JSON object with fields key
+// and value on its own line. Its output can be read with JSONInputFormat
+type JSONLinesOutputFormat struct{}
+
+// Writer returns a writer of records as JSON documents
+func (JSONLinesOutputFormat) Writer(w io.Writer) RecordWriter {
+	return &bufferedWriter{w: bufio.NewWriter(w), write: func(w *bufio.Writer,
+		key, value string) error {
+		data, err := json.Marshal(struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		}{key, value})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}}
+}
+
+// Committer returns the committer of the job output
+func (JSONLinesOutputFormat) Committer() OutputCommitter {
+	return FileOutputCommitter{}
+}
+
+// BinaryOutputFormat writes records in the binary key / value format of the
+// intermediate files, which preserves keys and values containing separators
+type BinaryOutputFormat struct{}
+
+// Writer returns a writer of records in binary format
+func (BinaryOutputFormat) Writer(w io.Writer) RecordWriter {
+	return &bufferedWriter{w: bufio.NewWriter(w), write: utils.WriteKeyValue}
+}
+
+// Committer returns the committer of the job output
+func (BinaryOutputFormat) Committer() OutputCommitter {
+	return FileOutputCommitter{}
+}
@@ -0,0 +1,610 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+const (
+	// SequenceFormat is the name of the input and output format of sequence
+	// files
+	SequenceFormat = "sequence"
+	// DefaultBlockSize is the size of the uncompressed blocks of records of
+	// sequence files written with no block size
+	DefaultBlockSize = 1 << 16
+	// syncSize is the size of the sync marker of sequence files
+	syncSize = 16
+	// trailerSize is the size of the trailer of sequence files, which stores
+	// the position of the block index
+	trailerSize = 12
+	// sequenceMagic starts the header of sequence files
+	sequenceMagic = "MRSEQ\x01"
+	// indexMagic ends the trailer of sequence files
+	indexMagic = "MRSI"
+)
+
+// Sequence files are splittable containers of key / value pairs. A sequence
+// file starts with a header storing the codec used to compress its blocks,
+// the types of keys and values and a random sync marker. The header is
+// followed by blocks of records, each starting with the sync marker, the
+// number of records and the size of the compressed records, which are stored
+// in the format of the intermediate files. A sync marker followed by a zero
+// record count marks the end of the blocks, and is followed by an index of
+// the blocks offsets and by a trailer storing the position of the end marker.
+// Readers can start reading from any offset by looking for the next sync
+// marker, which is how splits are read
+
+// codec compresses and decompresses the blocks of a sequence file
+type codec struct {
+	compress   func(data []byte) ([]byte, error)
+	decompress func(data []byte) ([]byte, error)
+}
+
+var codecs = map[string]codec{
+	"none": {
+		compress:   func(data []byte) ([]byte, error) { return data, nil },
+		decompress: func(data []byte) ([]byte, error) { return data, nil },
+	},
+	"gzip": {
+		compress: func(data []byte) ([]byte, error) {
+			return compressBlock(data, func(w io.Writer) (io.WriteCloser,
+				error) {
+				return gzip.NewWriter(w), nil
+			})
+		},
+		decompress: func(data []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(r)
+		},
+	},
+	"flate": {
+		compress: func(data []byte) ([]byte, error) {
+			return compressBlock(data, func(w io.Writer) (io.WriteCloser,
+				error) {
+				return flate.NewWriter(w, flate.DefaultCompression)
+			})
+		},
+		decompress: func(data []byte) ([]byte, error) {
+			return io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+		},
+	},
+}
+
+// compressBlock compresses data using the writer created by newWriter
+func compressBlock(data []byte,
+	newWriter func(io.Writer) (io.WriteCloser, error)) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SequenceHeader describes the content of a sequence file. Codec is the name
+// of the codec compressing the blocks: none, gzip or flate. KeyType and
+// ValueType describe the types of keys and values and are not interpreted by
+// the framework
+type SequenceHeader struct {
+	Codec              string
+	KeyType, ValueType string
+}
+
+// BlockInfo describes a block of a sequence file, starting at Offset and
+// holding Records records
+type BlockInfo struct {
+	Offset  int64
+	Records int
+}
+
+// countingWriter counts the bytes written to an underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes data to the underlying writer
+func (cw *countingWriter) Write(data []byte) (int, error) {
+	n, err := cw.w.Write(data)
+	cw.n += int64(n)
+	return n, err
+}
+
+// SequenceWriter writes key / value pairs to a sequence file. The header is
+// written together with the first block, and the block index when the writer
+// is closed
+type SequenceWriter struct {
+	header    SequenceHeader
+	codec     codec
+	blockSize int
+	sync      [syncSize]byte
+	cw        *countingWriter
+	w         *bufio.Writer
+	block     bytes.Buffer
+	bw        *bufio.Writer
+	records   int
+	index     []BlockInfo
+	started   bool
+	err       error
+}
+
+// NewSequenceWriter creates a writer of a sequence file to w. Blocks are
+// compressed once their uncompressed size reaches blockSize bytes, or
+// DefaultBlockSize bytes if blockSize is not positive
+func NewSequenceWriter(w io.Writer, header SequenceHeader,
+	blockSize int) *SequenceWriter {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	sw := &SequenceWriter{header: header, blockSize: blockSize,
+		cw: &countingWriter{w: w}}
+	sw.w = bufio.NewWriter(sw.cw)
+	sw.bw = bufio.NewWriter(&sw.block)
+
+	c, ok := codecs[header.Codec]
+	if !ok {
+		sw.err = fmt.Errorf("newsequencewriter: unknown codec: %s",
+			header.Codec)
+	}
+	sw.codec = c
+	if _, err := rand.Read(sw.sync[:]); err != nil && sw.err == nil {
+		sw.err = err
+	}
+	return sw
+}
+
+// pos returns the position of the next byte written to the file
+func (sw *SequenceWriter) pos() int64 {
+	return sw.cw.n + int64(sw.w.Buffered())
+}
+
+// writeString writes a length-prefixed string
+func writeString(w *bufio.Writer, s string) {
+	w.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	w.WriteString(s)
+}
+
+// writeHeader writes the file header, unless it has already been written
+func (sw *SequenceWriter) writeHeader() {
+	if sw.started {
+		return
+	}
+	sw.started = true
+	sw.w.WriteString(sequenceMagic)
+	writeString(sw.w, sw.header.Codec)
+	writeString(sw.w, sw.header.KeyType)
+	writeString(sw.w, sw.header.ValueType)
+	sw.w.Write(sw.sync[:])
+}
+
+// Write adds a key / value pair to the current block, and writes the block to
+// the file once it is full
+func (sw *SequenceWriter) Write(key, value string) error {
+	if sw.err != nil {
+		return sw.err
+	}
+	if sw.err = utils.WriteKeyValue(sw.bw, key, value); sw.err != nil {
+		return sw.err
+	}
+	sw.records++
+	if sw.block.Len()+sw.bw.Buffered() >= sw.blockSize {
+		sw.err = sw.flushBlock()
+	}
+	return sw.err
+}
+
+// flushBlock compresses the current block and writes it to the file
+func (sw *SequenceWriter) flushBlock() error {
+	if sw.records == 0 {
+		return nil
+	}
+	if err := sw.bw.Flush(); err != nil {
+		return err
+	}
+	data, err := sw.codec.compress(sw.block.Bytes())
+	if err != nil {
+		return err
+	}
+
+	sw.writeHeader()
+	sw.index = append(sw.index, BlockInfo{Offset: sw.pos(),
+		Records: sw.records})
+	sw.w.Write(sw.sync[:])
+	sw.w.Write(binary.AppendUvarint(nil, uint64(sw.records)))
+	sw.w.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	_, err = sw.w.Write(data)
+	sw.block.Reset()
+	sw.records = 0
+	return err
+}
+
+// Close writes the last block, the end marker, the block index and the
+// trailer, and flushes the file. It does not close the underlying writer
+func (sw *SequenceWriter) Close() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	if sw.err = sw.flushBlock(); sw.err != nil {
+		return sw.err
+	}
+
+	sw.writeHeader()
+	end := sw.pos()
+	sw.w.Write(sw.sync[:])
+	sw.w.Write(binary.AppendUvarint(nil, 0))
+	sw.w.Write(binary.AppendUvarint(nil, uint64(len(sw.index))))
+	for _, block := range sw.index {
+		sw.w.Write(binary.AppendUvarint(nil, uint64(block.Offset)))
+		sw.w.Write(binary.AppendUvarint(nil, uint64(block.Records)))
+	}
+	sw.w.Write(binary.BigEndian.AppendUint64(nil, uint64(end)))
+	sw.w.WriteString(indexMagic)
+	sw.err = sw.w.Flush()
+	if sw.err == nil {
+		sw.err = errors.New("write: sequence writer closed")
+		return nil
+	}
+	return sw.err
+}
+
+// posReader tracks the position of the next byte read from a file
+type posReader struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+// Read reads data from the file
+func (pr *posReader) Read(data []byte) (int, error) {
+	n, err := pr.r.Read(data)
+	pr.pos += int64(n)
+	return n, err
+}
+
+// ReadByte reads a byte from the file
+func (pr *posReader) ReadByte() (byte, error) {
+	b, err := pr.r.ReadByte()
+	if err == nil {
+		pr.pos++
+	}
+	return b, err
+}
+
+// readString reads a length-prefixed string
+func readString(r *posReader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return string(data), err
+}
+
+// SequenceReader reads the key / value pairs of a sequence file. Blocks
+// starting at or after the limit set with SetLimit are not read
+type SequenceReader struct {
+	f         *os.File
+	r         *posReader
+	header    SequenceHeader
+	codec     codec
+	sync      [syncSize]byte
+	dataStart int64
+	limit     int64
+	block     *bufio.Reader
+	left      int
+	done      bool
+}
+
+// OpenSequenceFile opens the sequence file at path and reads its header. The
+// reader is positioned at the first block
+func OpenSequenceFile(path string) (*SequenceReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &SequenceReader{f: f, r: &posReader{r: bufio.NewReader(f)},
+		limit: math.MaxInt64}
+	if err := sr.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("opensequencefile: %s: %v", path, err)
+	}
+	return sr, nil
+}
+
+// readHeader reads the file header
+func (sr *SequenceReader) readHeader() error {
+	magic := make([]byte, len(sequenceMagic))
+	if _, err := io.ReadFull(sr.r, magic); err != nil {
+		return err
+	}
+	if string(magic) != sequenceMagic {
+		return errors.New("not a sequence file")
+	}
+
+	fields := []*string{&sr.header.Codec, &sr.header.KeyType,
+		&sr.header.ValueType}
+	for _, field := range fields {
+		var err error
+		if *field, err = readString(sr.r); err != nil {
+			return err
+		}
+	}
+	c, ok := codecs[sr.header.Codec]
+	if !ok {
+		return fmt.Errorf("unknown codec: %s", sr.header.Codec)
+	}
+	sr.codec = c
+
+	if _, err := io.ReadFull(sr.r, sr.sync[:]); err != nil {
+		return err
+	}
+	sr.dataStart = sr.r.pos
+	return nil
+}
+
+// Header returns the header of the file
+func (sr *SequenceReader) Header() SequenceHeader {
+	return sr.header
+}
+
+// SetLimit sets the offset of the first block not to be read
+func (sr *SequenceReader) SetLimit(limit int64) {
+	sr.limit = limit
+}
+
+// Sync positions the reader at the first sync marker found at or after the
+// specified offset. The next record returned is the first record of the
+// block starting at that marker
+func (sr *SequenceReader) Sync(offset int64) error {
+	if offset < sr.dataStart {
+		offset = sr.dataStart
+	}
+	if _, err := sr.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	sr.r.r.Reset(sr.f)
+	sr.r.pos = offset
+	sr.block, sr.left, sr.done = nil, 0, false
+
+	for {
+		data, err := sr.r.r.Peek(syncSize)
+		if err == io.EOF {
+			sr.done = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(data, sr.sync[:]) {
+			return nil
+		}
+		sr.r.ReadByte()
+	}
+}
+
+// nextBlock reads the block starting at the current position
+func (sr *SequenceReader) nextBlock() error {
+	if sr.r.pos >= sr.limit {
+		sr.done = true
+		return nil
+	}
+
+	marker := make([]byte, syncSize)
+	if _, err := io.ReadFull(sr.r, marker); err != nil {
+		return err
+	}
+	if !bytes.Equal(marker, sr.sync[:]) {
+		return fmt.Errorf("nextblock: missing sync marker at offset %d",
+			sr.r.pos-syncSize)
+	}
+
+	cnt, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		sr.done = true
+		return nil
+	}
+
+	size, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(sr.r, data); err != nil {
+		return err
+	}
+	if data, err = sr.codec.decompress(data); err != nil {
+		return err
+	}
+	sr.block = bufio.NewReader(bytes.NewReader(data))
+	sr.left = int(cnt)
+	return nil
+}
+
+// Next returns the next key / value pair of the file, or io.EOF once all the
+// blocks before the limit have been read
+func (sr *SequenceReader) Next() (string, string, error) {
+	for sr.left == 0 {
+		if sr.done {
+			return "", "", io.EOF
+		}
+		if err := sr.nextBlock(); err != nil {
+			return "", "", err
+		}
+	}
+
+	sr.left--
+	return utils.ReadKeyValue(sr.block)
+}
+
+// Blocks reads the block index of the file. The position of the reader is
+// not preserved
+func (sr *SequenceReader) Blocks() ([]BlockInfo, error) {
+	info, err := sr.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	trailer := make([]byte, trailerSize)
+	_, err = sr.f.ReadAt(trailer, info.Size()-trailerSize)
+	if err != nil {
+		return nil, err
+	}
+	if string(trailer[8:]) != indexMagic {
+		return nil, errors.New("blocks: missing block index")
+	}
+
+	end := int64(binary.BigEndian.Uint64(trailer))
+	if err := sr.Sync(end); err != nil {
+		return nil, err
+	}
+	if err := sr.nextBlock(); err != nil || !sr.done {
+		return nil, errors.New("blocks: missing end marker")
+	}
+	cnt, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]BlockInfo, 0, cnt)
+	for idx := uint64(0); idx < cnt; idx++ {
+		offset, err := binary.ReadUvarint(sr.r)
+		if err != nil {
+			return nil, err
+		}
+		records, err := binary.ReadUvarint(sr.r)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, BlockInfo{Offset: int64(offset),
+			Records: int(records)})
+	}
+	return res, nil
+}
+
+// Close closes the file
+func (sr *SequenceReader) Close() error {
+	return sr.f.Close()
+}
+
+// SequenceFileInputFormat reads sequence files. Each key / value pair is a
+// record
+type SequenceFileInputFormat struct{}
+
+// Splits divides a sequence file into splits made of whole blocks, using the
+// block index. Files without a block index are divided into splits of equal
+// size, whose readers look for the first block of their split
+func (SequenceFileInputFormat) Splits(path string, splitSize int64) ([]Split,
+	error) {
+	sr, err := OpenSequenceFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer sr.Close()
+
+	blocks, err := sr.Blocks()
+	if err != nil || splitSize <= 0 || len(blocks) == 0 {
+		return fileSplits(path, splitSize, true)
+	}
+
+	info, err := sr.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Split, 0)
+	start := int64(0)
+	for idx := 1; idx < len(blocks); idx++ {
+		if blocks[idx].Offset-start >= splitSize {
+			res = append(res, Split{Path: path, Offset: start,
+				Length: blocks[idx].Offset - start})
+			start = blocks[idx].Offset
+		}
+	}
+	return append(res, Split{Path: path, Offset: start,
+		Length: info.Size() - start}), nil
+}
+
+// Reader returns a reader of the records of the blocks starting in a split
+func (SequenceFileInputFormat) Reader(split Split) (RecordReader, error) {
+	sr, err := OpenSequenceFile(split.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := sr.Sync(split.Offset); err != nil {
+		sr.Close()
+		return nil, err
+	}
+	sr.SetLimit(split.Offset + split.Length)
+	return &sequenceRecordReader{sr: sr, split: split}, nil
+}
+
+// sequenceRecordReader reads the records of a split of a sequence file
+type sequenceRecordReader struct {
+	sr    *SequenceReader
+	split Split
+}
+
+// Next returns the next record of the split
+func (r *sequenceRecordReader) Next() (Record, error) {
+	key, value, err := r.sr.Next()
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Key: key, Value: value}, nil
+}
+
+// BytesRead returns the number of bytes of the split read so far
+func (r *sequenceRecordReader) BytesRead() int64 {
+	if r.sr.done && r.sr.left == 0 {
+		return r.split.Length
+	}
+	read := r.sr.r.pos - r.split.Offset
+	if read > r.split.Length {
+		read = r.split.Length
+	}
+	return read
+}
+
+// Close closes the file read by the reader
+func (r *sequenceRecordReader) Close() error {
+	return r.sr.Close()
+}
+
+// SequenceFileOutputFormat writes sequence files of string keys and values,
+// whose blocks are compressed with the specified codec
+type SequenceFileOutputFormat struct {
+	Codec string
+}
+
+// Writer returns a writer of records to a sequence file
+func (f SequenceFileOutputFormat) Writer(w io.Writer) RecordWriter {
+	return NewSequenceWriter(w, SequenceHeader{Codec: f.Codec,
+		KeyType: "string", ValueType: "string"}, DefaultBlockSize)
+}
+
+// Committer returns the committer of the job output
+func (SequenceFileOutputFormat) Committer() OutputCommitter {
+	return FileOutputCommitter{}
+}
@@ -0,0 +1,88 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSequenceFile writes cnt records to a sequence file with small blocks
+func writeSequenceFile(t *testing.T, path, codec string, cnt int) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	sw := NewSequenceWriter(f, SequenceHeader{Codec: codec}, 256)
+	for idx := 0; idx < cnt; idx++ {
+		err := sw.Write(fmt.Sprintf("key%04d", idx), fmt.Sprint(idx))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSequenceFileSplits(t *testing.T) {
+	const cnt = 500
+	for _, codec := range []string{"none", "gzip", "flate"} {
+		path := filepath.Join(t.TempDir(), "data.seq")
+		writeSequenceFile(t, path, codec, cnt)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Index-based splits and splits at arbitrary offsets
+		format := SequenceFileInputFormat{}
+		indexed, err := format.Splits(path, 1024)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		arbitrary, err := fileSplits(path, 97, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(indexed) < 2 || len(arbitrary) <= len(indexed) {
+			t.Fatalf("%s: unexpected split counts: %d, %d", codec,
+				len(indexed), len(arbitrary))
+		}
+
+		for _, splits := range [][]Split{indexed, arbitrary} {
+			next := 0
+			var length int64
+			for _, split := range splits {
+				length += split.Length
+				rr, err := format.Reader(split)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				for {
+					rec, err := rr.Next()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					if rec.Key != fmt.Sprintf("key%04d", next) ||
+						rec.Value != fmt.Sprint(next) {
+						t.Fatalf("%s: expected record %d, actual: %v", codec,
+							next, rec)
+					}
+					next++
+				}
+				rr.Close()
+			}
+			if next != cnt || length != info.Size() {
+				t.Errorf("%s: expected %d records, actual: %d", codec, cnt,
+					next)
+			}
+		}
+	}
+}
@@ -2,20 +2,156 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"log/slog"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/giulioborghesi/mapreduce/app"
+	"github.com/giulioborghesi/mapreduce/common"
+	"github.com/giulioborghesi/mapreduce/master"
+	"github.com/giulioborghesi/mapreduce/workers"
 )
 
 func main() {
+	if err := run(); err != nil {
+		slog.Error("master failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the command line arguments and runs the master
+func run() error {
 	// Parse arguments
 	wrkrPtr := flag.String("workers", "localhost:1234", "Worker/workers address")
-	rCntPtr := flag.Int("reducer_tasks", 1, "Number of reducer tasks")
+	inptPtr := flag.String("inputs", "/Users/giulioborghesi/tmp/example.dat",
+		"Comma separated list of input files")
+	namePtr := flag.String("name", "wordcount", "Job name")
+	rCntPtr := flag.Int("reducer_tasks", 1,
+		"Number of reducer tasks, 0 for a map-only job")
+	slowPtr := flag.Float64("reduce_slowstart", 0.05,
+		"Fraction of map tasks to complete before scheduling reduce tasks")
+	topoPtr := flag.String("topology", "", "File mapping hosts to racks")
+	hintPtr := flag.String("locations", "",
+		"File mapping map task indices to the hosts storing their input")
+	waitPtr := flag.Duration("locality_wait", 3*time.Second,
+		"Time a map task waits for a slot at each locality level")
+	dmonPtr := flag.Bool("daemon", false,
+		"Run as a long-running master accepting job submissions")
+	addrPtr := flag.String("address", "localhost:1233",
+		"Master address, used in daemon mode and to serve side files")
+	jobsPtr := flag.Int("max_jobs", 4, "Maximum number of concurrent jobs")
+	schdPtr := flag.String("scheduler", "",
+		"File configuring how worker slots are shared among jobs")
+	poolPtr := flag.String("pool", "", "Pool of the job")
+	progPtr := flag.String("program", "", "Program executed by the job")
+	frmtPtr := flag.String("input_format", "",
+		"Input format of the job: text, tsv, ndjson, csv, whole_file or "+
+			"sequence")
+	mcmdPtr := flag.String("mapper", "",
+		"Map command of a streaming job, run by the shell")
+	rcmdPtr := flag.String("reducer", "",
+		"Reduce command of a streaming job, run by the shell")
+	tmotPtr := flag.Duration("stream_timeout", 0,
+		"Maximum running time of the commands of a streaming job")
+	wasmPtr := flag.String("wasm", "",
+		"WebAssembly module implementing the map and reduce functions")
+	tagdPtr := flag.String("tagged_inputs", "",
+		"Comma separated list of tag[:format]=path inputs, replacing -inputs")
+	noutPtr := flag.String("named_outputs", "",
+		"Comma separated list of name[:format] outputs written by the tasks")
+	filsPtr := flag.String("files", "",
+		"Comma separated list of side files shipped to the workers")
+	ofmtPtr := flag.String("output_format", "",
+		"Output format of the job: text, tsv, jsonl, binary or sequence")
+	splsPtr := flag.Int64("split_size_mb", 0,
+		"Maximum size of the input splits in MB, 64 if zero")
+	outpPtr := flag.String("output", "",
+		"Output directory of the job, standard output if empty")
+	wflwPtr := flag.String("workflow", "", "Workflow file to run")
+	resmPtr := flag.Bool("resume", false,
+		"Skip the workflow steps whose output is complete")
+	var logCfg common.LogConfig
+	flag.TextVar(&logCfg.Level, "log_level", slog.LevelInfo,
+		"Minimum level of the logged messages: DEBUG, INFO, WARN or ERROR")
+	flag.BoolVar(&logCfg.JSON, "log_json", false, "Log messages as JSON")
 	flag.Parse()
+	slog.SetDefault(slog.New(logCfg.Handler(os.Stderr)))
 
 	// Unroll worker addresses
 	addrs := strings.Split(*wrkrPtr, ",")
 
 	// Start the master instance
-	app.StartMaster(addrs, "/Users/giulioborghesi/tmp/example.dat", *rCntPtr)
+	cfg := master.Config{ReduceSlowStart: *slowPtr, LocalityWait: *waitPtr,
+		MaxRunningJobs: *jobsPtr, CacheAddress: *addrPtr}
+	if *topoPtr != "" {
+		topology, err := app.LoadTopology(*topoPtr)
+		if err != nil {
+			return fmt.Errorf("cannot load topology: %v", err)
+		}
+		cfg.Topology = topology
+	}
+	if *schdPtr != "" {
+		scheduler, err := app.LoadSchedulerConfig(*schdPtr)
+		if err != nil {
+			return fmt.Errorf("cannot load scheduler configuration: %v", err)
+		}
+		cfg.Scheduler = scheduler
+	}
+	if *dmonPtr {
+		return app.StartMasterDaemon(*addrPtr, addrs, cfg)
+	}
+
+	// Run a single workflow
+	if *wflwPtr != "" {
+		spec, err := app.LoadWorkflow(*wflwPtr)
+		if err != nil {
+			return fmt.Errorf("cannot load workflow: %v", err)
+		}
+		spec.Resume = *resmPtr
+		return app.RunWorkflow(addrs, spec, cfg)
+	}
+
+	// Run a single job
+	spec := master.JobSpec{Name: *namePtr, Program: *progPtr, Pool: *poolPtr,
+		Inputs: strings.Split(*inptPtr, ","), InputFormat: *frmtPtr,
+		SplitSize: *splsPtr << 20, Output: *outpPtr, OutputFormat: *ofmtPtr,
+		Reducers: *rCntPtr}
+	if *mcmdPtr != "" {
+		spec.Streaming = &workers.Streaming{Mapper: *mcmdPtr,
+			Reducer: *rcmdPtr, Timeout: *tmotPtr}
+	}
+	if *wasmPtr != "" {
+		code, err := os.ReadFile(*wasmPtr)
+		if err != nil {
+			return fmt.Errorf("cannot read webassembly module: %v", err)
+		}
+		spec.Wasm = code
+	}
+	if *tagdPtr != "" {
+		tagged, err := app.ParseTaggedInputs(*tagdPtr)
+		if err != nil {
+			return err
+		}
+		spec.Inputs, spec.TaggedInputs = nil, tagged
+	}
+	if *noutPtr != "" {
+		outputs, err := app.ParseNamedOutputs(*noutPtr)
+		if err != nil {
+			return err
+		}
+		spec.NamedOutputs = outputs
+	}
+	if *filsPtr != "" {
+		spec.CacheFiles = strings.Split(*filsPtr, ",")
+	}
+	if *hintPtr != "" {
+		hints, err := app.LoadLocationHints(*hintPtr)
+		if err != nil {
+			return fmt.Errorf("cannot load location hints: %v", err)
+		}
+		spec.LocationHints = hints
+	}
+	return app.StartMaster(addrs, spec, cfg)
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/giulioborghesi/mapreduce/app"
+	"github.com/giulioborghesi/mapreduce/master"
+	"github.com/giulioborghesi/mapreduce/workers"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("submit failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the command line arguments and submits the requests to the master
+func run() error {
+	// Parse arguments
+	mstrPtr := flag.String("master", "localhost:1233", "Master address")
+	inptPtr := flag.String("inputs", "", "Comma separated list of input files")
+	namePtr := flag.String("name", "wordcount", "Job name")
+	poolPtr := flag.String("pool", "", "Pool of the job")
+	progPtr := flag.String("program", "", "Program executed by the job")
+	frmtPtr := flag.String("input_format", "",
+		"Input format of the job: text, tsv, ndjson, csv, whole_file or "+
+			"sequence")
+	mcmdPtr := flag.String("mapper", "",
+		"Map command of a streaming job, run by the shell")
+	rcmdPtr := flag.String("reducer", "",
+		"Reduce command of a streaming job, run by the shell")
+	tmotPtr := flag.Duration("stream_timeout", 0,
+		"Maximum running time of the commands of a streaming job")
+	wasmPtr := flag.String("wasm", "",
+		"WebAssembly module implementing the map and reduce functions")
+	tagdPtr := flag.String("tagged_inputs", "",
+		"Comma separated list of tag[:format]=path inputs, replacing -inputs")
+	noutPtr := flag.String("named_outputs", "",
+		"Comma separated list of name[:format] outputs written by the tasks")
+	filsPtr := flag.String("files", "",
+		"Comma separated list of side files shipped to the workers")
+	ofmtPtr := flag.String("output_format", "",
+		"Output format of the job: text, tsv, jsonl, binary or sequence")
+	splsPtr := flag.Int64("split_size_mb", 0,
+		"Maximum size of the input splits in MB, 64 if zero")
+	rCntPtr := flag.Int("reducer_tasks", 1,
+		"Number of reducer tasks, 0 for a map-only job")
+	hintPtr := flag.String("locations", "",
+		"File mapping map task indices to the hosts storing their input")
+	waitPtr := flag.Bool("wait", false, "Wait for the job to complete")
+	statPtr := flag.String("status", "", "Print the status of a job")
+	listPtr := flag.Bool("list", false, "List the jobs known to the master")
+	outpPtr := flag.String("output", "",
+		"Output directory of the job, standard output if empty")
+	wflwPtr := flag.String("workflow", "", "Workflow file to submit")
+	resmPtr := flag.String("resume", "", "Resume a failed workflow")
+	wfstPtr := flag.String("workflow_status", "",
+		"Print the status of a workflow")
+	flag.Parse()
+
+	// Handle workflow requests
+	var err error
+	switch {
+	case *wfstPtr != "":
+		err = app.PrintWorkflowStatus(*mstrPtr, *wfstPtr)
+	case *resmPtr != "":
+		_, err = app.ResumeWorkflow(*mstrPtr, *resmPtr, *waitPtr)
+	case *wflwPtr != "":
+		var spec master.WorkflowSpec
+		if spec, err = app.LoadWorkflow(*wflwPtr); err == nil {
+			_, err = app.SubmitWorkflow(*mstrPtr, spec, *waitPtr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *wfstPtr != "" || *resmPtr != "" || *wflwPtr != "" {
+		return nil
+	}
+
+	// Print jobs status if requested
+	if *listPtr || *statPtr != "" {
+		if err := app.PrintJobStatus(*mstrPtr, *statPtr); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// Submit job
+	spec := master.JobSpec{Name: *namePtr, Program: *progPtr, Pool: *poolPtr,
+		Inputs: strings.Split(*inptPtr, ","), InputFormat: *frmtPtr,
+		SplitSize: *splsPtr << 20, Output: *outpPtr, OutputFormat: *ofmtPtr,
+		Reducers: *rCntPtr}
+	if *mcmdPtr != "" {
+		spec.Streaming = &workers.Streaming{Mapper: *mcmdPtr,
+			Reducer: *rcmdPtr, Timeout: *tmotPtr}
+	}
+	if *wasmPtr != "" {
+		code, err := os.ReadFile(*wasmPtr)
+		if err != nil {
+			return fmt.Errorf("cannot read webassembly module: %v", err)
+		}
+		spec.Wasm = code
+	}
+	if *tagdPtr != "" {
+		tagged, err := app.ParseTaggedInputs(*tagdPtr)
+		if err != nil {
+			return err
+		}
+		spec.Inputs, spec.TaggedInputs = nil, tagged
+	}
+	if *noutPtr != "" {
+		outputs, err := app.ParseNamedOutputs(*noutPtr)
+		if err != nil {
+			return err
+		}
+		spec.NamedOutputs = outputs
+	}
+	if *filsPtr != "" {
+		spec.CacheFiles = strings.Split(*filsPtr, ",")
+	}
+	if *hintPtr != "" {
+		hints, err := app.LoadLocationHints(*hintPtr)
+		if err != nil {
+			return fmt.Errorf("cannot load location hints: %v", err)
+		}
+		spec.LocationHints = hints
+	}
+	_, err = app.SubmitJob(*mstrPtr, spec, *waitPtr)
+	return err
+}
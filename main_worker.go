@@ -2,15 +2,47 @@ package main
 
 import (
 	"flag"
+	"log/slog"
+	"os"
+	"time"
 
 	"github.com/giulioborghesi/mapreduce/app"
+	"github.com/giulioborghesi/mapreduce/common"
+	"github.com/giulioborghesi/mapreduce/workers"
 )
 
 func main() {
+	if err := run(); err != nil {
+		slog.Error("worker failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the command line arguments and runs the worker
+func run() error {
 	// Parse argument
 	addrPtr := flag.String("address", "localhost:1234", "Worker address")
+	shflPtr := flag.Int64("shuffle_memory_mb", 64,
+		"Memory available to reduce tasks for storing map outputs, in MB")
+	sortPtr := flag.Int64("sort_memory_mb", 64,
+		"Memory available to map tasks for buffering their output, in MB")
+	wasmPtr := flag.Int64("wasm_memory_mb", 64,
+		"Memory available to the WebAssembly modules of a task, in MB")
+	mSltPtr := flag.Int("map_slots", 1, "Number of concurrent Map tasks")
+	rSltPtr := flag.Int("reduce_slots", 1, "Number of concurrent Reduce tasks")
+	var logCfg common.LogConfig
+	flag.TextVar(&logCfg.Level, "log_level", slog.LevelInfo,
+		"Minimum level of the logged messages: DEBUG, INFO, WARN or ERROR")
+	flag.BoolVar(&logCfg.JSON, "log_json", false, "Log messages as JSON")
+	rtntPtr := flag.Duration("log_retention", 24*time.Hour,
+		"How long the task logs of a job are kept once the job has finished")
 	flag.Parse()
+	slog.SetDefault(slog.New(logCfg.Handler(os.Stderr)))
 
 	// Start a worker instance
-	app.StartWorker(*addrPtr)
+	cfg := workers.Config{ShuffleMemoryBytes: *shflPtr << 20,
+		SortMemoryBytes: *sortPtr << 20, MapSlots: *mSltPtr,
+		ReduceSlots: *rSltPtr, WasmMemoryBytes: *wasmPtr << 20, Log: logCfg,
+		LogRetention: *rtntPtr}
+	return app.StartWorker(*addrPtr, cfg)
 }
@@ -0,0 +1,48 @@
+package master
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/giulioborghesi/mapreduce/workers"
+)
+
+const (
+	// CachePath is the HTTP path under which the master serves the side
+	// files of the jobs
+	CachePath = "/cache/"
+)
+
+// ServeCache serves the side files and the WebAssembly modules of the jobs
+// known to the coordinator. Side files are requested by the workers at
+// CachePath/{job ID}/{file name}, and modules at CachePath/{job ID}/{module
+// hash}.wasm
+func (c *Coordinator) ServeCache(w http.ResponseWriter, r *http.Request) {
+	jobID, name, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, CachePath),
+		"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	c.mu.Lock()
+	j, ok := c.jobs[jobID]
+	c.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if j.wasm != "" && name == workers.WasmFileName(j.wasm) {
+		http.ServeContent(w, r, name, j.submitted,
+			bytes.NewReader(j.spec.Wasm))
+		return
+	}
+	path, ok := j.cachePath(name)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	http.ServeFile(w, r, path)
+}
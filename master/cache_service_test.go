@@ -0,0 +1,50 @@
+package master
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stopwords.txt")
+	if err := os.WriteFile(path, []byte("the\na\n"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Side files are described by name, checksum and size
+	files, err := cacheFiles([]string{path})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "beb23c7fb3d63c36d605caff072ebb5bc8ab01ecee500e6319aeab5559f729fa"
+	if len(files) != 1 || files[0].Name != "stopwords.txt" ||
+		files[0].Size != 6 || files[0].Checksum != want {
+		t.Errorf("Side files incorrect, got: %v", files)
+	}
+	if _, err := cacheFiles([]string{path, path}); err == nil {
+		t.Errorf("Duplicate side file names should be rejected")
+	}
+
+	// Side files are served for known jobs only
+	c, err := MakeCoordinator(nil, Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.jobs["job_0001"] = &job{spec: JobSpec{CacheFiles: []string{path}}}
+	for url, code := range map[string]int{
+		"/cache/job_0001/stopwords.txt": http.StatusOK,
+		"/cache/job_0001/other.txt":     http.StatusNotFound,
+		"/cache/job_0002/stopwords.txt": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		c.ServeCache(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != code {
+			t.Errorf("Status of %s incorrect, got: %d, want: %d", url,
+				w.Code, code)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package master
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"sync"
+
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+// clientsPool keeps a persistent RPC client for each worker. Clients are
+// created on first use and recreated transparently when their connection
+// breaks. Workers that cannot be reached are reported to the workers manager
+type clientsPool struct {
+	clients map[int32]*rpc.Client
+	wm      *workersManager
+	sync.Mutex
+}
+
+// makeClientsPool creates a new, empty clientsPool object
+func makeClientsPool(wm *workersManager) *clientsPool {
+	return &clientsPool{clients: make(map[int32]*rpc.Client), wm: wm}
+}
+
+// call invokes the specified method on a worker and waits for it to complete
+// or for the context to be done. A call that fails because of a broken
+// connection is retried once on a new connection. If the worker cannot be
+// reached, it is reported as failed to the workers manager
+func (p *clientsPool) call(ctx context.Context, wrkrID int32, method string,
+	args interface{}, reply interface{}) error {
+	for {
+		client, fresh, err := p.client(ctx, wrkrID)
+		if err != nil {
+			p.wm.reportFailedWorker(wrkrID)
+			return err
+		}
+
+		err = utils.CallContext(ctx, client, method, args, reply)
+		if err == nil || !isConnectionError(err) {
+			return err
+		}
+
+		p.discard(wrkrID, client)
+		if fresh {
+			p.wm.reportFailedWorker(wrkrID)
+			return err
+		}
+	}
+}
+
+// client returns the client associated with a worker, creating one if needed.
+// The boolean return value is true if the client has just been created
+func (p *clientsPool) client(ctx context.Context,
+	wrkrID int32) (*rpc.Client, bool, error) {
+	p.Lock()
+	client, ok := p.clients[wrkrID]
+	p.Unlock()
+	if ok {
+		return client, false, nil
+	}
+
+	addr := p.wm.worker(wrkrID).addr
+	client, err := utils.DialHTTPContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Another goroutine may have connected to the worker in the meantime
+	p.Lock()
+	defer p.Unlock()
+	if curr, ok := p.clients[wrkrID]; ok {
+		client.Close()
+		return curr, false, nil
+	}
+	p.clients[wrkrID] = client
+	return client, true, nil
+}
+
+// discard closes a client and removes it from the pool, unless it has been
+// replaced already
+func (p *clientsPool) discard(wrkrID int32, client *rpc.Client) {
+	p.Lock()
+	defer p.Unlock()
+
+	if curr, ok := p.clients[wrkrID]; ok && curr == client {
+		delete(p.clients, wrkrID)
+	}
+	client.Close()
+}
+
+// remove closes the client associated with a worker, if any, and removes it
+// from the pool
+func (p *clientsPool) remove(wrkrID int32) {
+	p.Lock()
+	defer p.Unlock()
+
+	if client, ok := p.clients[wrkrID]; ok {
+		client.Close()
+		delete(p.clients, wrkrID)
+	}
+}
+
+// close closes all the clients in the pool
+func (p *clientsPool) close() {
+	p.Lock()
+	defer p.Unlock()
+
+	for wrkrID, client := range p.clients {
+		client.Close()
+		delete(p.clients, wrkrID)
+	}
+}
+
+// isConnectionError returns true if an RPC call failed because of a problem
+// with the underlying connection, and false if the call reached the worker or
+// was abandoned by the caller
+func isConnectionError(err error) bool {
+	if _, ok := err.(rpc.ServerError); ok {
+		return false
+	}
+	return !errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
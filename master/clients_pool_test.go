@@ -0,0 +1,89 @@
+package master
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/rpc"
+	"testing"
+)
+
+// pingService is a minimal RPC service used to test the clients pool
+type pingService struct{}
+
+// Ping returns its argument incremented by one
+func (pingService) Ping(args int, reply *int) error {
+	*reply = args + 1
+	return nil
+}
+
+func TestClientsPool(t *testing.T) {
+	// Serve the ping service over HTTP on a random port
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Ping", pingService{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	go http.Serve(l, mux)
+
+	// The second worker cannot be reached: its port is closed
+	closed, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	closed.Close()
+	wm := makeWorkersManager([]worker{
+		{id: 0, addr: l.Addr().String(), status: healthy},
+		{id: 1, addr: closed.Addr().String(), status: healthy}})
+	wm.activeCnt = 2
+	p := makeClientsPool(wm)
+
+	// Clients are reused across calls
+	ctx := context.Background()
+	reply := 0
+	for i := 0; i < 2; i++ {
+		if err := p.call(ctx, 0, "Ping.Ping", i, &reply); err != nil ||
+			reply != i+1 {
+			t.Fatalf("Call failed, got: (%d, %v), want: %d", reply, err, i+1)
+		}
+	}
+	client := p.clients[0]
+	if len(p.clients) != 1 || client == nil {
+		t.Fatalf("Client not pooled, got: %v", p.clients)
+	}
+
+	// Broken connections are replaced transparently
+	client.Close()
+	if err := p.call(ctx, 0, "Ping.Ping", 5, &reply); err != nil ||
+		reply != 6 {
+		t.Fatalf("Call failed, got: (%d, %v), want: %d", reply, err, 6)
+	}
+	if p.clients[0] == client || !wm.isActive(0) {
+		t.Errorf("Broken client not replaced")
+	}
+
+	// Unreachable workers are reported as failed and have no client
+	if err := p.call(ctx, 1, "Ping.Ping", 0, &reply); err == nil {
+		t.Errorf("Call to unreachable worker should fail")
+	}
+	if _, ok := p.clients[1]; ok || wm.isActive(1) {
+		t.Errorf("Unreachable worker not reported as failed")
+	}
+
+	// Removed and closed pools hold no client
+	p.remove(0)
+	if _, ok := p.clients[0]; ok {
+		t.Errorf("Removed client still pooled")
+	}
+	p.call(ctx, 0, "Ping.Ping", 0, &reply)
+	p.close()
+	if len(p.clients) != 0 {
+		t.Errorf("Closed pool still holds clients: %v", p.clients)
+	}
+}
@@ -1,43 +1,74 @@
 package master
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/giulioborghesi/mapreduce/common"
-	"github.com/giulioborghesi/mapreduce/utils"
 	"github.com/giulioborghesi/mapreduce/workers"
 )
 
 const (
-	maxGoRoutines     = 20
 	sleepTimeInMs     = 500
 	taskDeadlineInMin = 10
+	// maxTaskFailures is the number of times a task can fail before its job
+	// is marked as failed
+	maxTaskFailures = 4
+	// defaultHistorySize is the number of completed jobs remembered by the
+	// coordinator when no history size is configured
+	defaultHistorySize = 100
 )
 
-// Coordinator manages workers and coordinates tasks execution
-type Coordinator struct {
-	done bool
-	file string
-	ts   tasksScheduler
-	tm   tasksManager
-	wm   workersManager
+// Config holds the configuration parameters of a Coordinator
+type Config struct {
+	// ReduceSlowStart is the fraction of map tasks that must complete before
+	// reduce tasks are scheduled
+	ReduceSlowStart float64
+	// Topology maps hosts to racks
+	Topology map[string]string
+	// LocalityWait is the time a map task waits for a node-local slot before
+	// accepting a rack-local slot, and for a rack-local slot before accepting
+	// any slot
+	LocalityWait time.Duration
+	// MaxRunningJobs is the maximum number of jobs executed concurrently.
+	// Jobs submitted when this limit is reached are queued
+	MaxRunningJobs int
+	// HistorySize is the number of completed jobs whose status is retained
+	HistorySize int
+	// Scheduler configures how the workers slots are shared among jobs
+	Scheduler SchedulerConfig
+	// CacheAddress is the address at which the coordinator serves the side
+	// files and the WebAssembly modules of the jobs to the workers. Jobs
+	// with side files or modules are rejected if empty
+	CacheAddress string
 }
 
-// createMapReduceTasks creates the MapReduce tasks for the MapReduce
-// computation
-func createMapReduceTasks(mapperCnt, reducerCnt int) []task {
-	tsks := make([]task, 0, mapperCnt+reducerCnt)
-	for idx := 0; idx < mapperCnt; idx++ {
-		id := int32(idx)
-		tsks = append(tsks, makeMapperTask(id, idx, mapperCnt, reducerCnt))
-	}
-
-	for idx := 0; idx < reducerCnt; idx++ {
-		id := int32(idx + mapperCnt)
-		tsks = append(tsks, makeReducerTask(id, idx, mapperCnt, reducerCnt))
-	}
-	return tsks
+// Coordinator manages workers and coordinates the execution of the tasks of
+// the jobs submitted to it. Jobs are started in submission order and share
+// the pool of workers according to the configured scheduling policy. Job IDs
+// include the start time of the coordinator, so that they are not reused
+// when the master restarts
+type Coordinator struct {
+	cfg       Config
+	done      bool
+	jobs      map[string]*job
+	queue     []*job
+	running   []*job
+	history   []*job
+	tsk2job   map[int32]*job
+	workflows map[string]*workflow
+	nextTskID int32
+	nextJobID int
+	nextWfID  int
+	started   int64
+	mu        sync.Mutex
+	cp        clientsPool
+	ts        tasksScheduler
+	wm        workersManager
 }
 
 // createMapReduceWorkers creates the MapReduce workers for the MapReduce
@@ -51,58 +82,419 @@ func createMapReduceWorkers(addrs []string) []worker {
 	return wrkrs
 }
 
-// MakeCoordinator initializes and returns a task coordinator
-func MakeCoordinator(addrs []string, file string,
-	mapperCnt, reducerCnt int) *Coordinator {
-	tsks := createMapReduceTasks(mapperCnt, reducerCnt)
+// MakeCoordinator initializes and returns a task coordinator managing the
+// workers with the specified addresses. An error is returned if the
+// configuration is not valid
+func MakeCoordinator(addrs []string, cfg Config) (*Coordinator, error) {
+	policy, err := makeSchedulingPolicy(cfg.Scheduler)
+	if err != nil {
+		return nil, err
+	}
+
 	wrkrs := createMapReduceWorkers(addrs)
+	if cfg.MaxRunningJobs <= 0 {
+		cfg.MaxRunningJobs = 1
+	}
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = defaultHistorySize
+	}
 
 	c := new(Coordinator)
+	c.cfg = cfg
+	c.started = time.Now().UnixMilli()
 	c.done = false
-	c.file = file
-	c.tm = *makeTasksManager(tsks)
+	c.jobs = make(map[string]*job)
+	c.tsk2job = make(map[int32]*job)
+	c.workflows = make(map[string]*workflow)
 	c.wm = *makeWorkersManager(wrkrs)
-	c.ts = *makeTasksScheduler(wrkrs, tsks)
-	return c
+	c.ts = *makeTasksScheduler(wrkrs, nil, policy, cfg.Topology,
+		cfg.LocalityWait)
+	c.cp = *makeClientsPool(&c.wm)
+	return c, nil
 }
 
-// Run starts the MapReduce computation on the Master side
+// Submit queues a new job for execution and returns its ID. The job output is
+// set up when the job starts
+func (c *Coordinator) Submit(spec JobSpec) (string, error) {
+	return c.submit(spec, false)
+}
+
+// submit queues a new job for execution and returns its ID. Jobs resuming a
+// workflow step clear the partial output of the step when they start
+func (c *Coordinator) submit(spec JobSpec, resume bool) (string, error) {
+	inputs, err := expandInputs(spec.Inputs)
+	if err != nil {
+		return "", err
+	}
+	spec.Inputs = inputs
+	spec.TaggedInputs = append([]TaggedInput(nil), spec.TaggedInputs...)
+	for idx := range spec.TaggedInputs {
+		inputs, err := expandInputs(spec.TaggedInputs[idx].Inputs)
+		if err != nil {
+			return "", err
+		}
+		spec.TaggedInputs[idx].Inputs = inputs
+	}
+	if err := validateJobSpec(spec); err != nil {
+		return "", err
+	}
+	splits, err := computeSplits(spec)
+	if err != nil {
+		return "", err
+	}
+	if (len(spec.CacheFiles) > 0 || len(spec.Wasm) > 0) &&
+		c.cfg.CacheAddress == "" {
+		return "", fmt.Errorf("submit: side files and modules are not " +
+			"served by the master")
+	}
+	cache, err := cacheFiles(spec.CacheFiles)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextJobID++
+	id := fmt.Sprintf("job_%d_%04d", c.started, c.nextJobID)
+	j := makeJob(id, spec, splits, c.nextTskID)
+	c.nextTskID += int32(len(j.tsks))
+	j.resume = resume
+	j.cache = cache
+	j.cacheURL = "http://" + c.cfg.CacheAddress + CachePath + id
+
+	c.jobs[id] = j
+	c.queue = append(c.queue, j)
+	for _, tsk := range j.tsks {
+		c.tsk2job[tsk.id] = j
+	}
+	slog.Info("job submitted", "job", id, "name", spec.Name)
+	return id, nil
+}
+
+// JobStatus returns the status of the job with the specified ID
+func (c *Coordinator) JobStatus(id string) (JobStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	j, ok := c.jobs[id]
+	if !ok {
+		return JobStatus{}, fmt.Errorf("jobstatus: unknown job: %s", id)
+	}
+	return j.status(), nil
+}
+
+// Jobs returns the status of the completed jobs, followed by the status of the
+// running and of the queued jobs
+func (c *Coordinator) Jobs() []JobStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := make([]JobStatus, 0, len(c.jobs))
+	for _, js := range [][]*job{c.history, c.running, c.queue} {
+		for _, j := range js {
+			res = append(res, j.status())
+		}
+	}
+	return res
+}
+
+// Wait blocks until the job with the specified ID completes and returns its
+// final status
+func (c *Coordinator) Wait(id string) (JobStatus, error) {
+	c.mu.Lock()
+	j, ok := c.jobs[id]
+	c.mu.Unlock()
+	if !ok {
+		return JobStatus{}, fmt.Errorf("wait: unknown job: %s", id)
+	}
+
+	<-j.done
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return j.status(), nil
+}
+
+// Stop interrupts the coordinator main loop. Running jobs are not completed
+func (c *Coordinator) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done = true
+}
+
+// stopped returns true if the coordinator has been stopped
+func (c *Coordinator) stopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// Run starts the MapReduce computations on the Master side. It executes the
+// submitted jobs until Stop is called. Each worker slot is served by its own
+// goroutine, so that all the slots advertised by the workers can be used
 func (c *Coordinator) Run() {
-	for i := 0; i < utils.Min(maxGoRoutines, c.wm.activeWorkers()); i++ {
+	slots := c.registerWorkers()
+	for i := 0; i < slots; i++ {
 		go c.executeTask()
 	}
 
-	for {
-		// Nothing to do if no worker is available
-		if c.wm.activeWorkers() == 0 {
-			log.Fatalln("run: no worker left: aborting mapreduce computation")
+	for !c.stopped() {
+		// Update workers status and release connections to dead workers
+		wrkrsStatus := c.wm.updatedWorkersStatus(&c.cp)
+		for wrkrID, wrkrStatus := range wrkrsStatus {
+			if wrkrStatus == dead {
+				c.cp.remove(wrkrID)
+			}
+		}
+
+		// Start queued jobs, then update the status of the running ones
+		c.startJobs()
+		for _, j := range c.runningJobs() {
+			c.updateJob(j, wrkrsStatus)
+		}
+		c.updateProgress()
+		if c.cfg.Scheduler.PreemptionTimeout > 0 {
+			c.preemptTasks()
+		}
+
+		// Wait and then repeat. Wake up the goroutines waiting for a task,
+		// since tasks waiting for a local slot may now accept a non-local one
+		time.Sleep(sleepTimeInMs * time.Millisecond)
+		c.ts.cv.Broadcast()
+	}
+	c.ts.cv.Broadcast()
+	c.cp.close()
+}
+
+// startJobs starts queued jobs, in submission order, until the maximum
+// number of running jobs is reached. The output of a job is set up when the
+// job starts, and jobs whose output cannot be set up fail. Only the map
+// tasks of a job are scheduled when the job starts
+func (c *Coordinator) startJobs() {
+	c.mu.Lock()
+	var failed []*job
+	var reasons []string
+	for len(c.queue) > 0 && len(c.running) < c.cfg.MaxRunningJobs {
+		j := c.queue[0]
+		c.queue = c.queue[1:]
+		err := c.setupOutput(j)
+		c.running = append(c.running, j)
+
+		j.state = jobRunning
+		j.started = time.Now()
+		if err != nil {
+			failed, reasons = append(failed, j), append(reasons, err.Error())
+			continue
+		}
+		c.ts.addJob(j.id, j.spec.Pool)
+		for idx := range j.tsks[:j.mapperCnt] {
+			c.ts.addTask(&j.tsks[idx])
 		}
+		slog.Info("job started", "job", j.id, "pool", j.spec.Pool)
+	}
+	c.mu.Unlock()
 
-		// Update workers and tasks status
-		wrkrsStatus := c.wm.updatedWorkersStatus()
-		tsksStatus := c.tm.updatedTasksStatus(wrkrsStatus)
+	for idx, j := range failed {
+		c.finishJob(j, jobFailed, reasons[idx])
+	}
+}
 
-		// Reschedule tasks if needed
-		for tskID, tskStatus := range tsksStatus {
-			if tskStatus == failed {
-				c.ts.addTask(tskID, c.tm.task(tskID).priority)
+// setupOutput sets up the output of a job about to start. Jobs writing to the
+// output directory of a running job are rejected. The caller must hold the
+// coordinator lock
+func (c *Coordinator) setupOutput(j *job) error {
+	if j.spec.Output != "" {
+		output := filepath.Clean(j.spec.Output)
+		for _, r := range c.running {
+			if r.spec.Output != "" && filepath.Clean(r.spec.Output) == output {
+				return fmt.Errorf("setupoutput: output %s is written by job "+
+					"%s", j.spec.Output, r.id)
 			}
 		}
+	}
+
+	committer, err := setupJobOutput(j.spec, j.resume)
+	if err != nil {
+		return err
+	}
+	j.committer = committer
+	return nil
+}
+
+// runningJobs returns the jobs currently running
+func (c *Coordinator) runningJobs() []*job {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*job(nil), c.running...)
+}
+
+// isRunning returns true if the specified job is running
+func (c *Coordinator) isRunning(j *job) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return j.state == jobRunning
+}
+
+// updateJob updates the status of the tasks of a running job based on the
+// workers status, reschedules failed tasks and completes the job when all its
+// reduce tasks, or all its map tasks for a map-only job, have completed or
+// when it cannot be completed
+func (c *Coordinator) updateJob(j *job, wrkrsStatus map[int32]workerStatus) {
+	// Nothing to do if no worker is available
+	if c.wm.activeWorkers() == 0 {
+		c.finishJob(j, jobFailed, "no worker left")
+		return
+	}
+
+	// Cancel the attempts running on failed workers, in case the workers are
+	// still alive, and reschedule tasks if needed
+	tsksStatus := j.tm.updatedTasksStatus(wrkrsStatus)
+	c.cancelAttempts(j.tm.lostAttempts())
+	for tskID, tskStatus := range tsksStatus {
+		if tskStatus == failed {
+			c.ts.addTask(j.tm.task(tskID))
+		}
+	}
+
+	// Fail the job if a task failed too many times, and complete it if all
+	// tasks producing the job output have completed
+	if tskID, ok := j.tm.exhaustedTask(maxTaskFailures); ok {
+		c.finishJob(j, jobFailed, fmt.Sprintf("task %d failed %d times",
+			tskID, maxTaskFailures))
+		return
+	}
+	if j.tm.tasksLeft() == 0 {
+		c.finishJob(j, jobSucceeded, "")
+		return
+	}
+
+	// Schedule reduce tasks if enough map tasks have completed, then update
+	// the data sources. Map-only jobs have no data sources
+	c.scheduleReduceTasks(j)
+	if j.spec.Reducers > 0 {
+		c.updateDataSources(j, tsksStatus, wrkrsStatus)
+	}
+	slog.Debug("job status", "job", j.id, "summary", j.tm.summary())
+}
+
+// finishJob marks a running job as completed with the specified final state,
+// cancels its attempts still in progress, removes its tasks from the tasks
+// scheduler and moves it to the jobs history. The output of the attempts
+// completing later is not committed. The output of a successful job is
+// marked as complete, and the workers release the resources of the job. The
+// oldest completed jobs are forgotten when the history is full
+func (c *Coordinator) finishJob(j *job, state jobState, reason string) {
+	j.tm.close()
+	c.cancelAttempts(j.tm.runningAttempts())
+	c.ts.removeJob(j.id)
+	c.releaseJob(j)
+	if state == jobSucceeded && j.spec.Output != "" {
+		if err := j.committer.CommitJob(j.spec.Output); err != nil {
+			state, reason = jobFailed, err.Error()
+		}
+	}
+	if state == jobFailed && j.spec.Output != "" && j.committer != nil {
+		j.committer.AbortJob(j.spec.Output)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		// Interrupt the computation if all reduce tasks have completed
-		if c.tm.reduceTasksLeft() == 0 {
+	j.state = state
+	j.err = reason
+	j.finished = time.Now()
+	for i := range c.running {
+		if c.running[i] == j {
+			c.running = append(c.running[:i], c.running[i+1:]...)
 			break
 		}
+	}
 
-		// Update the data sources
-		c.updateDataSources(tsksStatus, wrkrsStatus)
+	c.history = append(c.history, j)
+	for len(c.history) > c.cfg.HistorySize {
+		old := c.history[0]
+		c.history = c.history[1:]
+		delete(c.jobs, old.id)
+		for _, tsk := range old.tsks {
+			delete(c.tsk2job, tsk.id)
+		}
+	}
+	close(j.done)
 
-		// Some tasks have not completed yet. Wait and then repeat
-		log.Printf("Reduce tasks not completed yet: %d",
-			c.tm.reduceTasksLeft())
-		time.Sleep(sleepTimeInMs * time.Millisecond)
+	if state == jobFailed {
+		slog.Error("job failed", "job", j.id, "reason", reason)
+		return
 	}
-	log.Println("MapReduce computation completed!")
+	slog.Info("job completed", "job", j.id)
+}
+
+// registerWorkers retrieves the number of slots offered by each worker and
+// makes them available to the task scheduler. Workers that cannot be reached
+// are marked as dead. The total number of slots is returned
+func (c *Coordinator) registerWorkers() int {
+	chans := make(map[int32]chan *workers.WorkerInfo)
+	for wrkrID := range c.wm.wrkrs {
+		rchn := make(chan *workers.WorkerInfo)
+		chans[wrkrID] = rchn
+		go func(wrkrID int32) {
+			info := new(workers.WorkerInfo)
+			if err := c.callWorker(wrkrID, registerTask, workers.Void{},
+				info, statusDeadlineInMs*time.Millisecond); err != nil {
+				rchn <- nil
+				return
+			}
+			rchn <- info
+		}(wrkrID)
+	}
+
+	slots := 0
+	for wrkrID, rchn := range chans {
+		info := <-rchn
+		if info == nil {
+			c.wm.reportFailedWorker(wrkrID)
+			continue
+		}
+
+		wrkr := c.wm.worker(wrkrID)
+		wrkr.slots[mapSlot] = info.MapSlots
+		wrkr.slots[reduceSlot] = info.ReduceSlots
+		for slot := mapSlot; slot < slotKinds; slot++ {
+			for i := 0; i < wrkr.slots[slot]; i++ {
+				c.ts.addWorker(wrkrID, slot)
+			}
+			slots += wrkr.slots[slot]
+		}
+	}
+	return slots
+}
+
+// scheduleReduceTasks adds the reduce tasks of a job to the tasks scheduler
+// once the fraction of completed map tasks reaches the slow start threshold.
+// Reduce tasks are scheduled only once
+func (c *Coordinator) scheduleReduceTasks(j *job) {
+	if len(j.reduceTsks) == 0 {
+		return
+	}
+
+	if float64(j.tm.mapTasksDone()) < c.cfg.ReduceSlowStart*
+		float64(j.mapperCnt) {
+		return
+	}
+
+	slog.Info("scheduling reduce tasks", "job", j.id,
+		"map_tasks_done", j.tm.mapTasksDone(), "map_tasks", j.mapperCnt)
+	for _, tskID := range j.reduceTsks {
+		c.ts.addTask(j.tm.task(tskID))
+	}
+	j.reduceTsks = nil
+}
+
+// taskJob returns the job a task belongs to, or nil if the job has been
+// forgotten
+func (c *Coordinator) taskJob(tskID int32) *job {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tsk2job[tskID]
 }
 
 // executeTask pops tasks from the queue and executes them
@@ -113,92 +505,232 @@ func (c *Coordinator) executeTask() {
 		c.ts.cv.L.Lock()
 		for !c.ts.hasReadyTask() {
 			c.ts.cv.Wait()
-			if c.done {
+			if c.stopped() {
+				c.ts.cv.L.Unlock()
 				return
 			}
 		}
 
-		// Fetch next task to be executed, release lock and update task status
-		tskID, wrkrID := c.ts.nextTask()
+		// Fetch next task to be executed and release lock. Drop the task if
+		// its job is not running anymore
+		tskID, wrkrID, slot := c.ts.nextTask()
 		c.ts.cv.L.Unlock()
-		c.tm.assignWorkerToTask(wrkrID, tskID)
+		j := c.taskJob(tskID)
+		if j == nil || !c.isRunning(j) {
+			if j != nil {
+				c.ts.release(j.id, slot)
+			}
+			c.ts.addWorker(wrkrID, slot)
+			continue
+		}
+		attempt := j.tm.assignWorkerToTask(wrkrID, tskID)
 
-		// Create client with timeout. On error, mark worker as dead
-		addr := c.wm.worker(wrkrID).addr
-		client, err := utils.DialHTTP("tcp", addr, taskDeadlineInMin*
-			time.Minute)
+		// Prepare and submit request, then wait for task to complete. On
+		// error, cancel the attempt and mark worker as dead
+		tsk := j.tm.task(tskID)
+		ctx := j.requestContext(tsk, attempt)
+		reply := new(workers.TaskReply)
+		err := c.callWorker(wrkrID, tsk.method, ctx, reply,
+			taskDeadlineInMin*time.Minute)
+		c.ts.release(j.id, slot)
 		if err != nil {
+			c.cancelAttempts([]lostAttempt{{wrkrID: wrkrID,
+				attemptID: ctx.AttemptID}})
 			c.wm.reportFailedWorker(wrkrID)
 			continue
 		}
 
-		// Prepare and submit request
-		tsk := c.tm.task(tskID)
-		ctx := workers.RequestContext{Idx: tsk.idx, MapperCnt: tsk.mapperCnt,
-			ReducerCnt: tsk.reducerCnt, File: c.file}
-		reply := new(workers.Status)
-		call := client.Go(tsk.method, ctx, reply, nil)
+		// Update task status, committing the output of the attempt if the
+		// update is accepted and aborting it otherwise, then return worker
+		// slot to task scheduler, unless the worker was believed to have
+		// failed in the meantime
+		var commit func() error
+		if j.spec.Output != "" {
+			commit = func() error {
+				return j.committer.CommitTask(j.spec.Output, ctx.AttemptID)
+			}
+		}
+		accepted := j.tm.updateTaskStatus(reply, tskID, attempt, commit)
+		if !accepted && commit != nil {
+			j.committer.AbortTask(j.spec.Output, ctx.AttemptID)
+		}
+		if reply.Status == workers.FAILED && reply.Error != "" {
+			c.recordFailure(j, tskID, wrkrID, ctx.AttemptID, reply)
+		}
+		if c.wm.isActive(wrkrID) {
+			c.ts.addWorker(wrkrID, slot)
+		}
+	}
+}
 
-		// Wait for task to complete
-		res := <-call.Done
-		client.Close()
-		if res.Error != nil {
-			c.wm.reportFailedWorker(wrkrID)
+// recordFailure logs and records the failure of a task attempt of a job,
+// reported by the worker the attempt ran on
+func (c *Coordinator) recordFailure(j *job, tskID, wrkrID int32,
+	attemptID string, reply *workers.TaskReply) {
+	addr := c.wm.worker(wrkrID).addr
+	slog.Warn("task attempt failed", "job", j.id, "task", tskID,
+		"attempt", attemptID, "worker", addr, "error", reply.Error)
+	f := &AttemptFailure{AttemptID: attemptID, Worker: addr,
+		Error: reply.Error, LogTail: reply.LogTail,
+		LogURL: "http://" + addr + "/logs/" + j.id + "/" + attemptID}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	j.lastFailure = f
+}
+
+// preemptTasks preempts the reduce tasks of the jobs using more than their
+// share of reduce slots while other jobs have waited too long for a slot.
+// Preempted attempts are cancelled and their tasks rescheduled
+func (c *Coordinator) preemptTasks() {
+	preemptions := c.ts.preemptions(reduceSlot,
+		c.cfg.Scheduler.PreemptionTimeout)
+	for jobID, cnt := range preemptions {
+		c.mu.Lock()
+		j, ok := c.jobs[jobID]
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		attempts := j.tm.preemptTasks(cnt)
+		for _, attempt := range attempts {
+			slog.Info("preempting task attempt", "job", j.id,
+				"attempt", attempt.attemptID,
+				"worker", c.wm.worker(attempt.wrkrID).addr)
+		}
+		c.cancelAttempts(attempts)
+	}
+}
+
+// cancelAttempts asks workers to cancel the specified task attempts. Requests
+// are sent asynchronously and their outcome is ignored
+func (c *Coordinator) cancelAttempts(attempts []lostAttempt) {
+	for _, attempt := range attempts {
+		go c.callWorker(attempt.wrkrID, cancelTask, attempt.attemptID,
+			new(workers.Void), statusDeadlineInMs*time.Millisecond)
+	}
+}
+
+// releaseJob notifies the workers that a job has finished, so that they can
+// release its resources, such as its side files and the hosts of its map
+// outputs. Workers that cannot be reached are ignored
+func (c *Coordinator) releaseJob(j *job) {
+	var wg sync.WaitGroup
+	for wrkrID := range c.wm.wrkrs {
+		if !c.wm.isActive(wrkrID) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(wrkrID int32) {
+			defer wg.Done()
+			c.callWorker(wrkrID, releaseJobTask, j.id, new(workers.Void),
+				statusDeadlineInMs*time.Millisecond)
+		}(wrkrID)
+	}
+	wg.Wait()
+}
+
+// updateProgress retrieves the progress of the task attempts running on busy
+// workers and records it in the tasks manager of the running jobs
+func (c *Coordinator) updateProgress() {
+	jobs := c.runningJobs()
+	busy := make(map[int32]bool)
+	for _, j := range jobs {
+		for _, wrkrID := range j.tm.busyWorkers() {
+			busy[wrkrID] = true
+		}
+	}
+
+	chans := make(map[int32]chan *workers.ProgressReport)
+	for wrkrID := range busy {
+		if !c.wm.isActive(wrkrID) {
 			continue
 		}
 
-		// Update task status and insert worker back into task scheduler
-		tskStatus := *res.Reply.(*workers.Status)
-		c.tm.updateTaskStatus(tskStatus, tskID)
-		c.ts.addWorker(wrkrID)
+		rchn := make(chan *workers.ProgressReport)
+		chans[wrkrID] = rchn
+		go func(wrkrID int32) {
+			reply := new(workers.ProgressReport)
+			if err := c.callWorker(wrkrID, progressTask, workers.Void{},
+				reply, statusDeadlineInMs*time.Millisecond); err != nil {
+				rchn <- nil
+				return
+			}
+			rchn <- reply
+		}(wrkrID)
+	}
+
+	for _, rchn := range chans {
+		reply := <-rchn
+		if reply == nil {
+			continue
+		}
+		for _, j := range jobs {
+			j.tm.updateProgress(reply.Attempts)
+		}
 	}
 }
 
-// updateDataSources updates the data sources in all workers
-func (c *Coordinator) updateDataSources(tsksStatus map[int32]taskStatus,
-	wrkrsStatus map[int32]workerStatus) {
-	// Prepare message
-	hosts := make(map[int]common.Host)
+// callWorker invokes a method on a worker through the clients pool and waits
+// at most for the specified duration for it to complete
+func (c *Coordinator) callWorker(wrkrID int32, method string, args,
+	reply interface{}, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return c.cp.call(ctx, wrkrID, method, args, reply)
+}
+
+// updateDataSources records the changes to the hosts storing the map outputs
+// of a job and sends them to the workers that have not seen them yet. Only
+// the changes since the last version acknowledged by a worker are sent, and
+// no message is sent to workers that are up to date
+func (c *Coordinator) updateDataSources(j *job,
+	tsksStatus map[int32]taskStatus, wrkrsStatus map[int32]workerStatus) {
+	// Record changes to the map outputs hosts
 	for tskID, tskStatus := range tsksStatus {
-		tsk := c.tm.task(tskID)
+		tsk := j.tm.task(tskID)
 		if tsk.method == reduceTask {
 			continue
 		}
 		if tskStatus == done {
 			wrkr := c.wm.worker(tsk.wrkrID)
-			hosts[tsk.idx] = common.Host(wrkr.addr)
+			j.sl.update(tsk.idx, common.Host(wrkr.addr))
 		} else {
-			hosts[tsk.idx] = ""
+			j.sl.update(tsk.idx, "")
 		}
 	}
 
-	// Send the message to all workers asynchronously
-	chans := make(map[int32]chan workers.Void)
-	ctx := workers.UpdateRequestContext{File: c.file, Hosts: hosts}
+	// Send the changes to the workers asynchronously
+	version := j.sl.version()
+	chans := make(map[int32]chan int64)
 	for wrkrID := range wrkrsStatus {
 		wrkr := c.wm.worker(wrkrID)
-		if wrkr.status == dead {
+		if wrkr.status == dead || j.acked[wrkrID] == version {
 			continue
 		}
 
-		rchn := make(chan workers.Void)
+		hosts, version := j.sl.delta(j.acked[wrkrID])
+		ctx := workers.UpdateRequestContext{JobID: j.id,
+			Since: j.acked[wrkrID], Version: version, Hosts: hosts}
+		rchn := make(chan int64)
 		chans[wrkrID] = rchn
-		go func() {
-			client, err := utils.DialHTTP("tcp", wrkr.addr,
+		go func(since int64) {
+			reply := new(workers.UpdateReply)
+			err := c.callWorker(wrkr.id, dataSourcesUpdateTask, &ctx, reply,
 				statusDeadlineInMs*time.Millisecond)
 			if err != nil {
-				rchn <- workers.Void{}
+				rchn <- since
 				return
 			}
-
-			client.Call(dataSourcesUpdateTask, &ctx, new(workers.Void))
-			client.Close()
-			rchn <- workers.Void{}
-		}()
+			rchn <- reply.Version
+		}(j.acked[wrkrID])
 	}
 
-	// Force synchronization
-	for _, rchn := range chans {
-		<-rchn
+	// Record the version acknowledged by each worker. Workers that missed
+	// some changes will receive them at the next update
+	for wrkrID, rchn := range chans {
+		j.acked[wrkrID] = <-rchn
 	}
 }
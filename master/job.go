@@ -0,0 +1,542 @@
+package master
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/roles"
+	"github.com/giulioborghesi/mapreduce/workers"
+)
+
+const (
+	// jobQueued means that the job is waiting to be started
+	jobQueued jobState = iota
+	// jobRunning means that the job tasks are being executed
+	jobRunning
+	// jobSucceeded means that all the job tasks have completed successfully
+	jobSucceeded
+	// jobFailed means that the job could not be completed
+	jobFailed
+)
+
+// jobState summarizes the state of a job
+type jobState int8
+
+// String returns a human readable representation of a job state
+func (s jobState) String() string {
+	switch s {
+	case jobQueued:
+		return "queued"
+	case jobRunning:
+		return "running"
+	case jobSucceeded:
+		return "succeeded"
+	case jobFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// JobSpec describes a MapReduce job submitted to the master. One Map task is
+// created for each split of the job inputs. Jobs with no Reducer tasks are
+// map-only jobs, whose Mapper tasks write their output directly to the job
+// output directory
+type JobSpec struct {
+	// Name is a human readable name of the job
+	Name string
+	// Program is the name of the registered program executed by the job
+	// tasks, the default program if empty
+	Program string
+	// Streaming describes the external commands run by the tasks of
+	// streaming jobs, which only use the program, if any, to partition and
+	// sort the intermediate keys
+	Streaming *workers.Streaming
+	// Wasm is the module implementing the map and reduce functions of
+	// WebAssembly jobs, which use the program like streaming jobs
+	Wasm []byte
+	// CacheFiles lists the side files, such as lookup tables, that the
+	// master ships to the workers before they run any task of the job. Side
+	// files are accessed by their base name
+	CacheFiles []string
+	// Pool is the pool whose share of the workers slots the job uses
+	Pool string
+	// Inputs lists the input files of the job. Inputs can include
+	// directories, such as the output directory of another job, in which
+	// case all the data files in the directory are used
+	Inputs []string
+	// TaggedInputs lists the inputs of jobs reading datasets with different
+	// formats, such as the datasets of a join, instead of Inputs. The
+	// records of each tagged input are read with its own input format, the
+	// job input format if empty, and carry its tag
+	TaggedInputs []TaggedInput
+	// InputFormat is the name of the input format dividing the input files
+	// into splits and reading their records, the text format if empty
+	InputFormat string
+	// SplitSize is the maximum size of the input splits in bytes, or
+	// DefaultSplitSize if zero
+	SplitSize int64
+	// Output is the directory where the job output is written, standard
+	// output if empty
+	Output string
+	// OutputFormat is the name of the output format of the job, the text
+	// format if empty
+	OutputFormat string
+	// NamedOutputs maps the named outputs of the job, which tasks can write
+	// to besides the job output, to their output format, the job output
+	// format if empty. Named outputs are stored in the subdirectories of the
+	// Output directory with the same name
+	NamedOutputs map[string]string
+	// Reducers is the number of Reducer tasks of the job
+	Reducers int
+	// LocationHints optionally maps the index of an input file to the hosts
+	// storing it
+	LocationHints map[int][]string
+}
+
+// JobStatus describes the status of a job, as reported to clients.
+// LastFailure describes the last task attempt of the job that failed with an
+// error, if any
+type JobStatus struct {
+	ID, Name, Pool, State        string
+	Submitted, Started, Finished time.Time
+	Summary                      string
+	Error                        string
+	Counters                     map[string]int64
+	LastFailure                  *AttemptFailure
+}
+
+// AttemptFailure describes a failed task attempt: the worker it ran on, the
+// error it failed with and the last lines of its log. The whole log is
+// served by the worker at LogURL
+type AttemptFailure struct {
+	AttemptID, Worker string
+	Error             string
+	LogTail           string
+	LogURL            string
+}
+
+// job represents a MapReduce job managed by the master. Besides the job
+// specification and state, a job stores the tasks manager tracking its
+// tasks, the reduce tasks that have not been scheduled yet and the log of
+// its map outputs hosts, together with the version of the log acknowledged
+// by each worker, the committer of the job output, set once the output is
+// set up when the job starts, whether the job resumes a workflow step, the
+// module of WebAssembly jobs, the side files of the job and its last failed
+// attempt
+type job struct {
+	id                           string
+	spec                         JobSpec
+	state                        jobState
+	err                          string
+	submitted, started, finished time.Time
+	mapperCnt                    int
+	tsks                         []task
+	reduceTsks                   []int32
+	acked                        map[int32]int64
+	sl                           sourcesLog
+	tm                           tasksManager
+	committer                    formats.OutputCommitter
+	resume                       bool
+	wasm                         string
+	cache                        []workers.CacheFile
+	cacheURL                     string
+	lastFailure                  *AttemptFailure
+	done                         chan workers.Void
+}
+
+// TaggedInput describes a dataset read by a job with multiple inputs. The
+// records read from the Inputs files using the input format named
+// InputFormat are tagged with Tag
+type TaggedInput struct {
+	Tag         string
+	Inputs      []string
+	InputFormat string
+}
+
+// inputSplit is a split of a job input file, together with the index of the
+// file in the job inputs, the input format used to read it and the tag of
+// its records
+type inputSplit struct {
+	split  formats.Split
+	input  int
+	format string
+	tag    string
+}
+
+// jobInputs returns the tagged inputs of a job. The untagged inputs of the
+// job come first, with an empty tag; the index of an input file in the job
+// inputs is its position in this list of inputs
+func jobInputs(spec JobSpec) []TaggedInput {
+	res := make([]TaggedInput, 0, len(spec.TaggedInputs)+1)
+	if len(spec.Inputs) > 0 {
+		res = append(res, TaggedInput{Inputs: spec.Inputs,
+			InputFormat: spec.InputFormat})
+	}
+	for _, input := range spec.TaggedInputs {
+		if input.InputFormat == "" {
+			input.InputFormat = spec.InputFormat
+		}
+		res = append(res, input)
+	}
+	return res
+}
+
+// computeSplits divides the inputs of a job into splits using their input
+// format
+func computeSplits(spec JobSpec) ([]inputSplit, error) {
+	splitSize := spec.SplitSize
+	if splitSize == 0 {
+		splitSize = formats.DefaultSplitSize
+	}
+
+	res := make([]inputSplit, 0, len(spec.Inputs))
+	idx := 0
+	for _, tagged := range jobInputs(spec) {
+		format, err := formats.LookupInputFormat(tagged.InputFormat)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, input := range tagged.Inputs {
+			splits, err := format.Splits(input, splitSize)
+			if err != nil {
+				return nil, err
+			}
+			for _, split := range splits {
+				res = append(res, inputSplit{split: split, input: idx,
+					format: tagged.InputFormat, tag: tagged.Tag})
+			}
+			idx++
+		}
+	}
+	return res, nil
+}
+
+// cacheFiles describes the side files of a job. Side files must be regular
+// files with distinct base names
+func cacheFiles(paths []string) ([]workers.CacheFile, error) {
+	res := make([]workers.CacheFile, 0, len(paths))
+	names := make(map[string]bool)
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if names[name] {
+			return nil, fmt.Errorf("cachefiles: duplicate side file name: %s",
+				name)
+		}
+		names[name] = true
+
+		checksum, size, err := fileChecksum(path)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, workers.CacheFile{Name: name, Checksum: checksum,
+			Size: size})
+	}
+	return res, nil
+}
+
+// fileChecksum returns the hex encoded SHA-256 digest of a regular file and
+// its size
+func fileChecksum(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return "", 0, fmt.Errorf("filechecksum: %s is not a regular file",
+			path)
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// cachePath returns the path of the side file of the job with the specified
+// name, if any
+func (j *job) cachePath(name string) (string, bool) {
+	for _, path := range j.spec.CacheFiles {
+		if filepath.Base(path) == name {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// setupJobOutput sets up the output directory of a job, if any, and returns
+// the committer of the job output. The partial output left by a previous run
+// of a resumed workflow step is cleared first
+func setupJobOutput(spec JobSpec, resume bool) (formats.OutputCommitter,
+	error) {
+	format, err := formats.LookupOutputFormat(spec.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	committer := format.Committer()
+	if spec.Output == "" {
+		return committer, nil
+	}
+	if resume {
+		if err := committer.ClearJob(spec.Output); err != nil {
+			return nil, err
+		}
+	}
+	if err := committer.SetupJob(spec.Output); err != nil {
+		return nil, err
+	}
+	return committer, nil
+}
+
+// makeJob creates a new job from a job specification and the splits of its
+// inputs. The IDs of the job tasks are assigned sequentially starting from
+// firstTskID
+func makeJob(id string, spec JobSpec, splits []inputSplit,
+	firstTskID int32) *job {
+	mapperCnt := len(splits)
+	tsks := createMapReduceTasks(firstTskID, mapperCnt, spec.Reducers)
+	for idx, s := range splits {
+		tsks[idx].split = s.split
+		tsks[idx].format = s.format
+		tsks[idx].tag = s.tag
+		tsks[idx].hosts = spec.LocationHints[s.input]
+	}
+	for idx := range tsks {
+		tsks[idx].job = id
+	}
+	if spec.Pool == "" {
+		spec.Pool = defaultPool
+	}
+
+	j := &job{id: id, spec: spec, state: jobQueued, submitted: time.Now(),
+		mapperCnt: mapperCnt, tsks: tsks, acked: make(map[int32]int64),
+		done: make(chan workers.Void)}
+	for _, tsk := range tsks[mapperCnt:] {
+		j.reduceTsks = append(j.reduceTsks, tsk.id)
+	}
+	if len(spec.Wasm) > 0 {
+		j.wasm = workers.WasmHash(spec.Wasm)
+	}
+	j.sl = *makeSourcesLog()
+	j.tm = *makeTasksManager(tsks)
+	return j
+}
+
+// createMapReduceTasks creates the MapReduce tasks for the MapReduce
+// computation
+func createMapReduceTasks(firstTskID int32, mapperCnt, reducerCnt int) []task {
+	tsks := make([]task, 0, mapperCnt+reducerCnt)
+	for idx := 0; idx < mapperCnt; idx++ {
+		id := firstTskID + int32(idx)
+		tsks = append(tsks, makeMapperTask(id, idx, mapperCnt, reducerCnt))
+	}
+
+	for idx := 0; idx < reducerCnt; idx++ {
+		id := firstTskID + int32(idx+mapperCnt)
+		tsks = append(tsks, makeReducerTask(id, idx, mapperCnt, reducerCnt))
+	}
+	return tsks
+}
+
+// expandInputs replaces the directories in a list of inputs with the data
+// files they contain, in lexicographic order. Files whose name starts with
+// '_' or '.', such as job markers, are not data files. Inputs that cannot be
+// accessed are returned as they are
+func expandInputs(inputs []string) ([]string, error) {
+	res := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		if err != nil || !info.IsDir() {
+			res = append(res, input)
+			continue
+		}
+
+		entries, err := os.ReadDir(input)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || strings.HasPrefix(name, "_") ||
+				strings.HasPrefix(name, ".") {
+				continue
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			res = append(res, filepath.Join(input, name))
+		}
+	}
+	return res, nil
+}
+
+// checkOutput checks that the output directory of a job neither is nor
+// contains any of the job inputs, since the output of a job is never written
+// to a directory that is not empty
+func checkOutput(spec JobSpec) error {
+	if spec.Output == "" {
+		return nil
+	}
+
+	output := filepath.Clean(spec.Output)
+	for _, tagged := range jobInputs(spec) {
+		for _, input := range tagged.Inputs {
+			input = filepath.Clean(input)
+			if input == output ||
+				strings.HasPrefix(input, output+string(filepath.Separator)) {
+				return fmt.Errorf("checkoutput: output %s contains input %s",
+					spec.Output, input)
+			}
+		}
+	}
+	return nil
+}
+
+// validateJobSpec checks that a job specification describes a job that can be
+// executed
+func validateJobSpec(spec JobSpec) error {
+	if len(spec.Inputs) == 0 && len(spec.TaggedInputs) == 0 {
+		return fmt.Errorf("validatejobspec: no input file specified")
+	}
+	tags := make(map[string]bool)
+	for _, input := range spec.TaggedInputs {
+		if input.Tag == "" || tags[input.Tag] {
+			return fmt.Errorf("validatejobspec: missing or duplicate input "+
+				"tag: %q", input.Tag)
+		}
+		tags[input.Tag] = true
+		if len(input.Inputs) == 0 {
+			return fmt.Errorf("validatejobspec: no input file specified "+
+				"for tag %s", input.Tag)
+		}
+		if _, err := formats.LookupInputFormat(input.InputFormat); err != nil {
+			return err
+		}
+	}
+	if spec.Reducers < 0 {
+		return fmt.Errorf("validatejobspec: invalid number of reduce tasks: "+
+			"%d", spec.Reducers)
+	}
+	if spec.SplitSize < 0 {
+		return fmt.Errorf("validatejobspec: invalid split size: %d",
+			spec.SplitSize)
+	}
+	if _, err := formats.LookupInputFormat(spec.InputFormat); err != nil {
+		return err
+	}
+	if _, err := formats.LookupOutputFormat(spec.OutputFormat); err != nil {
+		return err
+	}
+	if err := checkOutput(spec); err != nil {
+		return err
+	}
+	if len(spec.NamedOutputs) > 0 && spec.Output == "" {
+		return fmt.Errorf("validatejobspec: named outputs require an " +
+			"output directory")
+	}
+	for name, format := range spec.NamedOutputs {
+		if name == "" || filepath.Base(name) != name ||
+			strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			return fmt.Errorf("validatejobspec: invalid named output: %q",
+				name)
+		}
+		if _, err := formats.LookupOutputFormat(format); err != nil {
+			return err
+		}
+	}
+
+	if s := spec.Streaming; s != nil {
+		if s.Mapper == "" || (spec.Reducers > 0 && s.Reducer == "") {
+			return fmt.Errorf("validatejobspec: streaming job has no map " +
+				"or reduce command")
+		}
+		if s.Timeout < 0 {
+			return fmt.Errorf("validatejobspec: invalid streaming timeout: "+
+				"%v", s.Timeout)
+		}
+		if len(spec.Wasm) > 0 {
+			return fmt.Errorf("validatejobspec: streaming job has a " +
+				"webassembly module")
+		}
+	}
+	if len(spec.Wasm) > 0 {
+		err := workers.ValidateWasmModule(spec.Wasm, spec.Reducers > 0)
+		if err != nil {
+			return err
+		}
+	}
+	if spec.Program == "" && (spec.Streaming != nil || len(spec.Wasm) > 0) {
+		return nil
+	}
+
+	prog, err := roles.Lookup(spec.Program)
+	if err != nil {
+		return err
+	}
+	if spec.Reducers > 0 && prog.Reduce == nil && spec.Streaming == nil &&
+		len(spec.Wasm) == 0 {
+		return fmt.Errorf("validatejobspec: program %s has no reduce "+
+			"function", spec.Program)
+	}
+	return nil
+}
+
+// requestContext returns the request context for an attempt of one of the job
+// tasks
+func (j *job) requestContext(tsk *task, attempt int32) workers.RequestContext {
+	return workers.RequestContext{Idx: tsk.idx, MapperCnt: tsk.mapperCnt,
+		ReducerCnt: tsk.reducerCnt, JobID: j.id, Program: j.spec.Program,
+		Streaming: j.spec.Streaming, Wasm: j.wasm, Cache: j.cache,
+		CacheURL: j.cacheURL, InputFormat: tsk.format, Source: tsk.tag,
+		Split: tsk.split, Output: j.spec.Output,
+		OutputFormat: j.spec.OutputFormat, NamedOutputs: j.namedOutputs(),
+		TaskID: tsk.id, AttemptID: attemptID(tsk.id, attempt)}
+}
+
+// namedOutputs returns the output format of the named outputs of the job
+func (j *job) namedOutputs() map[string]string {
+	if len(j.spec.NamedOutputs) == 0 {
+		return nil
+	}
+
+	res := make(map[string]string, len(j.spec.NamedOutputs))
+	for name, format := range j.spec.NamedOutputs {
+		if format == "" {
+			format = j.spec.OutputFormat
+		}
+		res[name] = format
+	}
+	return res
+}
+
+// status returns the job status. The caller must hold the coordinator lock
+func (j *job) status() JobStatus {
+	s := JobStatus{ID: j.id, Name: j.spec.Name, Pool: j.spec.Pool,
+		State:     j.state.String(),
+		Submitted: j.submitted, Started: j.started, Finished: j.finished,
+		Error: j.err, LastFailure: j.lastFailure}
+	if j.state != jobQueued {
+		s.Summary = j.tm.summary()
+		s.Counters = j.tm.counters()
+	}
+	return s
+}
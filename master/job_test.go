@@ -0,0 +1,42 @@
+package master
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckOutput(t *testing.T) {
+	for output, ok := range map[string]bool{
+		"/tmp/out":       true,
+		"/tmp/in":        false,
+		"/tmp":           false,
+		"/tmp/in/":       false,
+		"/tmp/in/part-1": true,
+	} {
+		spec := JobSpec{Inputs: []string{"/tmp/a.dat"}, Output: output,
+			TaggedInputs: []TaggedInput{{Tag: "users",
+				Inputs: []string{"/tmp/in/part-r-00000"}}}}
+		if err := checkOutput(spec); (err == nil) != ok {
+			t.Errorf("%s: unexpected result: %v", output, err)
+		}
+	}
+}
+
+func TestSetupOutput(t *testing.T) {
+	c, err := MakeCoordinator(nil, Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir := filepath.Join(t.TempDir(), "out")
+	running := &job{id: "job_1", spec: JobSpec{Output: dir}}
+	if err := c.setupOutput(running); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.running = append(c.running, running)
+
+	// Jobs cannot write to the output directory of a running job
+	if err := c.setupOutput(&job{id: "job_2",
+		spec: JobSpec{Output: dir + "/"}}); err == nil {
+		t.Errorf("expected error for output of running job")
+	}
+}
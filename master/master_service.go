@@ -0,0 +1,79 @@
+package master
+
+import "github.com/giulioborghesi/mapreduce/workers"
+
+// MasterService implements the RPC service used by clients to submit jobs to
+// a Coordinator and to monitor them
+type MasterService struct {
+	c *Coordinator
+}
+
+// MakeMasterService creates, initializes and returns an instance of a master
+// service backed by the specified coordinator
+func MakeMasterService(c *Coordinator) *MasterService {
+	return &MasterService{c: c}
+}
+
+// Submit is a RPC endpoint used by clients to submit a new job. The ID of the
+// job is returned to the client
+func (srvc *MasterService) Submit(spec JobSpec, jobID *string) error {
+	id, err := srvc.c.Submit(spec)
+	if err != nil {
+		return err
+	}
+	*jobID = id
+	return nil
+}
+
+// JobStatus is a RPC endpoint used by clients to retrieve the status of a job
+func (srvc *MasterService) JobStatus(jobID string, status *JobStatus) error {
+	s, err := srvc.c.JobStatus(jobID)
+	if err != nil {
+		return err
+	}
+	*status = s
+	return nil
+}
+
+// ListJobs is a RPC endpoint used by clients to retrieve the status of the
+// queued, running and completed jobs
+func (srvc *MasterService) ListJobs(_ workers.Void,
+	statuses *[]JobStatus) error {
+	*statuses = srvc.c.Jobs()
+	return nil
+}
+
+// SubmitWorkflow is a RPC endpoint used by clients to submit a new workflow.
+// The ID of the workflow is returned to the client
+func (srvc *MasterService) SubmitWorkflow(spec WorkflowSpec,
+	wfID *string) error {
+	id, err := srvc.c.SubmitWorkflow(spec)
+	if err != nil {
+		return err
+	}
+	*wfID = id
+	return nil
+}
+
+// ResumeWorkflow is a RPC endpoint used by clients to resume a failed
+// workflow. The ID of the resumed workflow is returned to the client
+func (srvc *MasterService) ResumeWorkflow(id string, wfID *string) error {
+	newID, err := srvc.c.ResumeWorkflow(id)
+	if err != nil {
+		return err
+	}
+	*wfID = newID
+	return nil
+}
+
+// WorkflowStatus is a RPC endpoint used by clients to retrieve the status of
+// a workflow
+func (srvc *MasterService) WorkflowStatus(wfID string,
+	status *WorkflowStatus) error {
+	s, err := srvc.c.WorkflowStatus(wfID)
+	if err != nil {
+		return err
+	}
+	*status = s
+	return nil
+}
@@ -0,0 +1,266 @@
+package master
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// FIFOPolicy offers free slots to jobs in submission order
+	FIFOPolicy = "fifo"
+	// FairPolicy divides slots equally among pools, or among jobs
+	FairPolicy = "fair"
+	// CapacityPolicy divides slots among pools in proportion to their
+	// capacity. Jobs within a pool are served in submission order
+	CapacityPolicy = "capacity"
+	// defaultPool is the pool of jobs submitted without a pool
+	defaultPool = "default"
+)
+
+// SchedulerConfig holds the configuration of the policy used to share the
+// workers slots among concurrent jobs
+type SchedulerConfig struct {
+	// Policy is the name of the scheduling policy. FIFOPolicy is used if
+	// empty
+	Policy string
+	// PerJob divides slots equally among jobs rather than among pools when
+	// the fair policy is used
+	PerJob bool
+	// Pools maps pool names to their weight with the fair policy, and to
+	// their capacity with the capacity policy. Unlisted pools have weight 1
+	// and capacity 0
+	Pools map[string]float64
+	// PreemptionTimeout is the time a job must wait below its share of
+	// reduce slots before reduce tasks of jobs above their share are
+	// preempted. Preemption is disabled if zero
+	PreemptionTimeout time.Duration
+}
+
+// jobShare tracks the slots used and requested by a job, as seen by the
+// tasks scheduler. For each kind of slot, starved records since when the job
+// has been below its share of slots
+type jobShare struct {
+	id      string
+	pool    string
+	running [slotKinds]int
+	pending [slotKinds]int
+	starved [slotKinds]time.Time
+}
+
+// demand returns the number of slots of the specified kind a job could use
+func (js *jobShare) demand(slot slotKind) int {
+	return js.running[slot] + js.pending[slot]
+}
+
+// schedulingPolicy decides how the slots of a kind are shared among jobs.
+// Jobs are passed in submission order, and shares returns the number of slots
+// each job is entitled to out of total slots
+type schedulingPolicy interface {
+	shares(jobs []*jobShare, slot slotKind, total int) []float64
+}
+
+// makeSchedulingPolicy creates the scheduling policy described by a scheduler
+// configuration
+func makeSchedulingPolicy(cfg SchedulerConfig) (schedulingPolicy, error) {
+	switch cfg.Policy {
+	case "", FIFOPolicy:
+		return fifoPolicy{}, nil
+	case FairPolicy:
+		return fairPolicy{perJob: cfg.PerJob, weights: cfg.Pools}, nil
+	case CapacityPolicy:
+		return capacityPolicy{capacities: cfg.Pools}, nil
+	}
+	return nil, fmt.Errorf("makeschedulingpolicy: unknown policy: %s",
+		cfg.Policy)
+}
+
+// fifoPolicy gives slots to jobs in submission order, each job receiving
+// as many slots as it can use
+type fifoPolicy struct{}
+
+func (fifoPolicy) shares(jobs []*jobShare, slot slotKind,
+	total int) []float64 {
+	return fifoShares(jobs, slot, float64(total))
+}
+
+// fairPolicy divides slots among pools in proportion to their weight and
+// then equally among the jobs of each pool. If perJob is true, slots are
+// divided equally among jobs regardless of their pool
+type fairPolicy struct {
+	perJob  bool
+	weights map[string]float64
+}
+
+func (p fairPolicy) shares(jobs []*jobShare, slot slotKind,
+	total int) []float64 {
+	if p.perJob {
+		return fairShares(jobs, slot, float64(total))
+	}
+
+	weight := func(pool string) float64 {
+		if w, ok := p.weights[pool]; ok {
+			return w
+		}
+		return 1
+	}
+	return poolShares(jobs, slot, total, weight, fairShares)
+}
+
+// capacityPolicy divides slots among pools in proportion to their capacity
+// and gives the slots of a pool to its jobs in submission order. Slots not
+// used by a pool are shared by the pools that can use them
+type capacityPolicy struct {
+	capacities map[string]float64
+}
+
+func (p capacityPolicy) shares(jobs []*jobShare, slot slotKind,
+	total int) []float64 {
+	weight := func(pool string) float64 {
+		return p.capacities[pool]
+	}
+	return poolShares(jobs, slot, total, weight, fifoShares)
+}
+
+// fifoShares gives slots to jobs in order, each job receiving as many slots
+// as it can use
+func fifoShares(jobs []*jobShare, slot slotKind, total float64) []float64 {
+	res := make([]float64, len(jobs))
+	for idx, js := range jobs {
+		res[idx] = math.Min(total, float64(js.demand(slot)))
+		total -= res[idx]
+	}
+	return res
+}
+
+// fairShares divides slots equally among jobs
+func fairShares(jobs []*jobShare, slot slotKind, total float64) []float64 {
+	demands := make([]int, len(jobs))
+	weights := make([]float64, len(jobs))
+	for idx, js := range jobs {
+		demands[idx] = js.demand(slot)
+		weights[idx] = 1
+	}
+	return waterFill(demands, weights, total)
+}
+
+// poolShares divides slots among the pools of the jobs in proportion to the
+// pool weights, and then among the jobs of each pool using within
+func poolShares(jobs []*jobShare, slot slotKind, total int,
+	weight func(string) float64,
+	within func([]*jobShare, slotKind, float64) []float64) []float64 {
+	pools := make([]string, 0)
+	members := make(map[string][]int)
+	for idx, js := range jobs {
+		if _, ok := members[js.pool]; !ok {
+			pools = append(pools, js.pool)
+		}
+		members[js.pool] = append(members[js.pool], idx)
+	}
+
+	demands := make([]int, len(pools))
+	weights := make([]float64, len(pools))
+	for idx, pool := range pools {
+		for _, jIdx := range members[pool] {
+			demands[idx] += jobs[jIdx].demand(slot)
+		}
+		weights[idx] = weight(pool)
+	}
+
+	res := make([]float64, len(jobs))
+	for idx, share := range waterFill(demands, weights, float64(total)) {
+		poolJobs := make([]*jobShare, 0, len(members[pools[idx]]))
+		for _, jIdx := range members[pools[idx]] {
+			poolJobs = append(poolJobs, jobs[jIdx])
+		}
+		for pos, s := range within(poolJobs, slot, share) {
+			res[members[pools[idx]][pos]] = s
+		}
+	}
+	return res
+}
+
+// waterFill divides total slots among consumers in proportion to their
+// weights, without giving any consumer more than its demand. Slots left once
+// the consumers with a positive weight are satisfied are divided equally
+// among the other consumers
+func waterFill(demands []int, weights []float64, total float64) []float64 {
+	res := make([]float64, len(demands))
+	active := make([]int, 0, len(demands))
+	for idx, demand := range demands {
+		if demand > 0 {
+			active = append(active, idx)
+		}
+	}
+
+	equal := false
+	for total > 1e-9 && len(active) > 0 {
+		weight := func(idx int) float64 {
+			if equal {
+				return 1
+			}
+			return math.Max(weights[idx], 0)
+		}
+
+		sum := 0.0
+		for _, idx := range active {
+			sum += weight(idx)
+		}
+		if sum == 0 {
+			equal = true
+			continue
+		}
+
+		// Give each consumer its part and drop the satisfied ones
+		left := total
+		next := make([]int, 0, len(active))
+		for _, idx := range active {
+			part := total * weight(idx) / sum
+			if res[idx]+part >= float64(demands[idx]) {
+				left -= float64(demands[idx]) - res[idx]
+				res[idx] = float64(demands[idx])
+				continue
+			}
+			res[idx] += part
+			left -= part
+			next = append(next, idx)
+		}
+
+		if len(next) == len(active) {
+			break
+		}
+		total, active = left, next
+	}
+	return res
+}
+
+// deficit returns the ratio between the slots used by a job and the slots it
+// is entitled to. Jobs with a lower deficit are offered free slots first
+func deficit(running int, share float64) float64 {
+	if share <= 0 {
+		return math.Inf(1)
+	}
+	return float64(running) / share
+}
+
+// orderJobs sorts jobs by increasing deficit. Jobs with the same deficit are
+// sorted in submission order
+func orderJobs(jobs []*jobShare, shares []float64, slot slotKind) []*jobShare {
+	idxs := make([]int, len(jobs))
+	for idx := range idxs {
+		idxs[idx] = idx
+	}
+
+	sort.SliceStable(idxs, func(a, b int) bool {
+		da := deficit(jobs[idxs[a]].running[slot], shares[idxs[a]])
+		db := deficit(jobs[idxs[b]].running[slot], shares[idxs[b]])
+		return da < db
+	})
+
+	res := make([]*jobShare, 0, len(jobs))
+	for _, idx := range idxs {
+		res = append(res, jobs[idx])
+	}
+	return res
+}
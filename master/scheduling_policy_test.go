@@ -0,0 +1,47 @@
+package master
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSchedulingPolicyShares(t *testing.T) {
+	// Create three jobs in two pools: the first job uses few slots, while
+	// the other two could use all the slots
+	jobs := []*jobShare{{id: "a", pool: "p1"}, {id: "b", pool: "p1"},
+		{id: "c", pool: "p2"}}
+	jobs[0].pending[mapSlot] = 2
+	jobs[1].pending[mapSlot] = 20
+	jobs[2].pending[mapSlot] = 20
+
+	tests := []struct {
+		policy schedulingPolicy
+		want   []float64
+	}{
+		{fifoPolicy{}, []float64{2, 8, 0}},
+		{fairPolicy{perJob: true}, []float64{2, 4, 4}},
+		{fairPolicy{}, []float64{2, 3, 5}},
+		{fairPolicy{weights: map[string]float64{"p1": 4}}, []float64{2, 6, 2}},
+		{capacityPolicy{capacities: map[string]float64{"p1": 0.2,
+			"p2": 0.8}}, []float64{2, 0, 8}},
+		{capacityPolicy{}, []float64{2, 3, 5}},
+	}
+
+	for _, test := range tests {
+		got := test.policy.shares(jobs, mapSlot, 10)
+		for idx := range got {
+			if math.Abs(got[idx]-test.want[idx]) > 1e-6 {
+				t.Errorf("%T: shares incorrect, got: %v, want: %v",
+					test.policy, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestUnknownSchedulingPolicy(t *testing.T) {
+	cfg := Config{Scheduler: SchedulerConfig{Policy: "lottery"}}
+	if _, err := MakeCoordinator([]string{"localhost:1234"}, cfg); err == nil {
+		t.Errorf("Unknown scheduling policy should be rejected")
+	}
+}
@@ -0,0 +1,68 @@
+package master
+
+import (
+	"sync"
+
+	"github.com/giulioborghesi/mapreduce/common"
+)
+
+// sourceEvent records a change of the host storing the output of a map task.
+// An empty host means that the output is not available anymore
+type sourceEvent struct {
+	idx  int
+	host common.Host
+}
+
+// sourcesLog keeps a versioned log of the changes to the hosts storing the
+// map outputs. The version of the log is the number of events recorded so
+// far, which allows clients to request only the changes they have not seen
+type sourcesLog struct {
+	events []sourceEvent
+	hosts  map[int]common.Host
+	sync.Mutex
+}
+
+// makeSourcesLog creates a new, empty sourcesLog object
+func makeSourcesLog() *sourcesLog {
+	return &sourcesLog{hosts: make(map[int]common.Host)}
+}
+
+// update records a new event if the host storing the output of the map task
+// with the specified index has changed
+func (l *sourcesLog) update(idx int, host common.Host) {
+	l.Lock()
+	defer l.Unlock()
+
+	if curr, ok := l.hosts[idx]; ok && curr == host {
+		return
+	}
+	if _, ok := l.hosts[idx]; !ok && host == "" {
+		return
+	}
+	l.hosts[idx] = host
+	l.events = append(l.events, sourceEvent{idx: idx, host: host})
+}
+
+// version returns the current version of the log
+func (l *sourcesLog) version() int64 {
+	l.Lock()
+	defer l.Unlock()
+	return int64(len(l.events))
+}
+
+// delta returns the latest host of each map output that changed after the
+// specified version, together with the current version of the log
+func (l *sourcesLog) delta(since int64) (map[int]common.Host, int64) {
+	l.Lock()
+	defer l.Unlock()
+
+	if since < 0 || since > int64(len(l.events)) {
+		since = 0
+	}
+
+	hosts := make(map[int]common.Host)
+	for _, event := range l.events[since:] {
+		hosts[event.idx] = event.host
+	}
+	return hosts, int64(len(l.events))
+}
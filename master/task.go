@@ -1,5 +1,13 @@
 package master
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/workers"
+)
+
 const (
 	// mapTask is the service method to be used for Map tasks
 	mapTask = "MapReduceService.Map"
@@ -10,6 +18,18 @@ const (
 	// dataSourcesUpdateTask is the service method to be used for updating the
 	// data sources
 	dataSourcesUpdateTask = "MapReduceService.UpdateSources"
+	// cancelTask is the service method to be used for cancelling a task
+	// attempt
+	cancelTask = "MapReduceService.Cancel"
+	// progressTask is the service method to be used for retrieving the
+	// progress of the task attempts running on a worker
+	progressTask = "MapReduceService.TaskProgress"
+	// registerTask is the service method to be used for retrieving the
+	// number of slots offered by a worker
+	registerTask = "MapReduceService.Register"
+	// releaseJobTask is the service method to be used for notifying a worker
+	// that a job has finished
+	releaseJobTask = "MapReduceService.ReleaseJob"
 	// invalidWorkerID is the ID used for tasks not assigned to a worker yet
 	invalidWorkerID = -1
 	// high is the priority of a Map task
@@ -18,20 +38,44 @@ const (
 	low = 1
 )
 
+const (
+	// mapSlot is the kind of worker slot used by Map tasks
+	mapSlot slotKind = iota
+	// reduceSlot is the kind of worker slot used by Reduce tasks
+	reduceSlot
+	// slotKinds is the number of kinds of worker slots
+	slotKinds
+)
+
+// slotKind identifies the kind of worker slot a task runs in
+type slotKind int8
+
 // task represents a generic task in a MapReduce computation. Aside
 // from storing basic information such as task id, status, priority
 // and task type, a task object also stores its position (idx) within
-// tasks of the same type and the number of its consumers / producers
+// tasks of the same type, the job it belongs to, the number of its
+// consumers / producers, the number of times it has been assigned to a
+// worker or has failed, the start time and progress of the latest attempt
+// and, for Map tasks, its input split, the input format used to read it, the
+// tag of its records and the hosts storing it
 type task struct {
 	id         int32
+	job        string
 	wrkrID     int32
+	attempt    int32
+	failures   int
 	idx        int
 	mapperCnt  int
 	reducerCnt int
 	priority   int8
 	method     string
-	filePath   string
+	split      formats.Split
+	format     string
+	tag        string
+	hosts      []string
 	status     taskStatus
+	started    time.Time
+	progress   workers.Progress
 }
 
 // makeMapperTask creates a new Mapper task
@@ -47,3 +91,35 @@ func makeReducerTask(id int32, idx, mapperCnt, reducerCnt int) task {
 		mapperCnt: mapperCnt, reducerCnt: reducerCnt, priority: low,
 		method: reduceTask, status: idle}
 }
+
+// attemptID returns the ID of the specified attempt of a task
+func attemptID(tskID, attempt int32) string {
+	return fmt.Sprintf("attempt_%d_%d", tskID, attempt)
+}
+
+// slot returns the kind of worker slot the task runs in
+func (tsk *task) slot() slotKind {
+	if tsk.method == mapTask {
+		return mapSlot
+	}
+	return reduceSlot
+}
+
+// completion returns the estimated fraction of the task that has completed.
+// The completion of a map task is measured by the fraction of input read,
+// while the completion of a reduce task is measured by the fraction of map
+// outputs fetched
+func (tsk *task) completion() float64 {
+	switch {
+	case tsk.status == done:
+		return 1
+	case tsk.status != inProgress:
+		return 0
+	case tsk.method == mapTask && tsk.progress.BytesTotal > 0:
+		return float64(tsk.progress.BytesRead) /
+			float64(tsk.progress.BytesTotal)
+	case tsk.method == reduceTask:
+		return tsk.progress.Shuffle
+	}
+	return 0
+}
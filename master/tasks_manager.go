@@ -2,25 +2,42 @@ package master
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/giulioborghesi/mapreduce/utils"
 	"github.com/giulioborghesi/mapreduce/workers"
 )
 
+// lostAttempt identifies a task attempt running on a worker that is believed
+// to have failed
+type lostAttempt struct {
+	wrkrID    int32
+	attemptID string
+}
+
 type tasksManager struct {
 	tsks     map[int32]*task
 	wrkr2tsk map[int32]map[int32]bool
+	attempts map[string]int32
+	lost     []lostAttempt
+	mapOnly  bool
+	closed   bool
 	tskLeft  int
+	mapDone  int
 	sync.Mutex
 }
 
 // makeTasksManager creates a new tasksManager object from a slice of tasks.
 // The tasks in the slice are required to have distinct IDs, otherwise the
-// function will panic
+// function will panic. If the slice contains no reduce tasks, the job is a
+// map-only job and completes when all its map tasks have completed
 func makeTasksManager(tsks []task) *tasksManager {
 	m := new(tasksManager)
 	m.tsks = make(map[int32]*task)
 	m.wrkr2tsk = make(map[int32]map[int32]bool)
+	m.attempts = make(map[string]int32)
 
 	reduceTskCnt := 0
 	for idx := range tsks {
@@ -34,14 +51,25 @@ func makeTasksManager(tsks []task) *tasksManager {
 			reduceTskCnt++
 		}
 	}
+	m.mapOnly = reduceTskCnt == 0
 	m.tskLeft = reduceTskCnt
+	if m.mapOnly {
+		m.tskLeft = len(tsks)
+	}
 	return m
 }
 
-// assignWorkerToTask assigns a worker to a task. This function will change the
-// task status to in progress and update the list of tasks assigned to the
-// worker. The task must be valid, otherwise the function will panic
-func (m *tasksManager) assignWorkerToTask(wrkrID, tskID int32) {
+// final returns true if the task produces the job output, that is if the
+// task is a reduce task or a map task of a map-only job
+func (m *tasksManager) final(tsk *task) bool {
+	return tsk.method == reduceTask || m.mapOnly
+}
+
+// assignWorkerToTask assigns a worker to a task and returns the number of the
+// new task attempt. This function will change the task status to in progress
+// and update the list of tasks assigned to the worker. The task must be valid,
+// otherwise the function will panic
+func (m *tasksManager) assignWorkerToTask(wrkrID, tskID int32) int32 {
 	m.Lock()
 	defer m.Unlock()
 
@@ -61,14 +89,25 @@ func (m *tasksManager) assignWorkerToTask(wrkrID, tskID int32) {
 	}
 	m.tsks[tskID].wrkrID = wrkrID
 	m.tsks[tskID].status = inProgress
+	m.tsks[tskID].attempt++
+	m.tsks[tskID].started = time.Now()
+	m.tsks[tskID].progress = workers.Progress{}
+	m.attempts[attemptID(tskID, m.tsks[tskID].attempt)] = tskID
+	return m.tsks[tskID].attempt
 }
 
-// reduceTasksLeft returns the number of reduce tasks left to complete the
-// MapReduce computation
-func (m *tasksManager) reduceTasksLeft() int {
+// tasksLeft returns the number of tasks producing the job output left to
+// complete the MapReduce computation
+func (m *tasksManager) tasksLeft() int {
 	return m.tskLeft
 }
 
+// mapTasksDone returns the number of map tasks that have completed
+// successfully
+func (m *tasksManager) mapTasksDone() int {
+	return m.mapDone
+}
+
 // task returns a pointer to the task with the specified id. This method will
 // panic if no task with the specified id exists
 func (m *tasksManager) task(tskID int32) *task {
@@ -93,6 +132,13 @@ func (m *tasksManager) updatedTasksStatus(
 
 		if wrkrStatus != healthy {
 			for tskID := range m.wrkr2tsk[wrkrID] {
+				tsk := m.tsks[tskID]
+				if tsk.status == inProgress {
+					id := attemptID(tskID, tsk.attempt)
+					m.lost = append(m.lost, lostAttempt{wrkrID: wrkrID,
+						attemptID: id})
+					delete(m.attempts, id)
+				}
 				m.tsks[tskID].wrkrID = invalidWorkerID
 				m.tsks[tskID].status = failed
 				m.wrkr2tsk[wrkrID] = make(map[int32]bool)
@@ -103,6 +149,7 @@ func (m *tasksManager) updatedTasksStatus(
 	// Reset failed tasks status to idle and append to return slice
 	res := make(map[int32]taskStatus, 0)
 	m.tskLeft = 0
+	m.mapDone = 0
 	for tskID := range m.tsks {
 		tsk := m.tsks[tskID]
 		res[tskID] = tsk.status
@@ -110,9 +157,181 @@ func (m *tasksManager) updatedTasksStatus(
 			tsk.status = idle
 		}
 
-		if tsk.method == reduceTask && tsk.status != done {
+		if m.final(tsk) && tsk.status != done {
 			m.tskLeft++
 		}
+		if tsk.method == mapTask && tsk.status == done {
+			m.mapDone++
+		}
+	}
+	return res
+}
+
+// busyWorkers returns the IDs of the workers running at least one task
+func (m *tasksManager) busyWorkers() []int32 {
+	m.Lock()
+	defer m.Unlock()
+
+	res := make([]int32, 0)
+	for wrkrID, tsks := range m.wrkr2tsk {
+		for tskID := range tsks {
+			if m.tsks[tskID].status == inProgress &&
+				m.tsks[tskID].wrkrID == wrkrID {
+				res = append(res, wrkrID)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// updateProgress records the progress of task attempts. Progress reported by
+// attempts other than the latest attempt of a task in progress is ignored
+func (m *tasksManager) updateProgress(report map[string]workers.Progress) {
+	m.Lock()
+	defer m.Unlock()
+
+	for id, progress := range report {
+		tskID, ok := m.attempts[id]
+		if !ok {
+			continue
+		}
+
+		tsk := m.tsks[tskID]
+		if tsk.status == inProgress && attemptID(tskID, tsk.attempt) == id {
+			tsk.progress = progress
+		}
+	}
+}
+
+// summary returns a human readable description of the progress of the map
+// and reduce tasks
+func (m *tasksManager) summary() string {
+	m.Lock()
+	defer m.Unlock()
+
+	var mapCnt, mapDone, reduceCnt, reduceDone int
+	var mapCompl, reduceCompl float64
+	for _, tsk := range m.tsks {
+		if tsk.method == mapTask {
+			mapCnt++
+			mapCompl += tsk.completion()
+			if tsk.status == done {
+				mapDone++
+			}
+		} else {
+			reduceCnt++
+			reduceCompl += tsk.completion()
+			if tsk.status == done {
+				reduceDone++
+			}
+		}
+	}
+
+	return fmt.Sprintf("map tasks: %d/%d done (%.1f%%), reduce tasks: "+
+		"%d/%d done (%.1f%%)", mapDone, mapCnt,
+		percentage(mapCompl, mapCnt), reduceDone, reduceCnt,
+		percentage(reduceCompl, reduceCnt))
+}
+
+// percentage returns the average completion of cnt tasks as a percentage
+func percentage(compl float64, cnt int) float64 {
+	if cnt == 0 {
+		return 100
+	}
+	return 100 * compl / float64(cnt)
+}
+
+// lostAttempts returns the task attempts that were running on failed workers
+// since the last call to this method
+func (m *tasksManager) lostAttempts() []lostAttempt {
+	m.Lock()
+	defer m.Unlock()
+
+	res := m.lost
+	m.lost = nil
+	return res
+}
+
+// runningAttempts returns the task attempts currently in progress
+func (m *tasksManager) runningAttempts() []lostAttempt {
+	m.Lock()
+	defer m.Unlock()
+
+	res := make([]lostAttempt, 0)
+	for tskID, tsk := range m.tsks {
+		if tsk.status == inProgress {
+			res = append(res, lostAttempt{wrkrID: tsk.wrkrID,
+				attemptID: attemptID(tskID, tsk.attempt)})
+		}
+	}
+	return res
+}
+
+// preemptTasks stops tracking the attempts of at most cnt reduce tasks in
+// progress, starting from the most recently started ones, and returns them.
+// Preempted tasks are rescheduled like failed tasks, but the preemption does
+// not count as a task failure
+func (m *tasksManager) preemptTasks(cnt int) []lostAttempt {
+	m.Lock()
+	defer m.Unlock()
+
+	tsks := make([]*task, 0)
+	for _, tsk := range m.tsks {
+		if tsk.method == reduceTask && tsk.status == inProgress {
+			tsks = append(tsks, tsk)
+		}
+	}
+	sort.Slice(tsks, func(i, j int) bool {
+		return tsks[i].started.After(tsks[j].started)
+	})
+
+	res := make([]lostAttempt, 0, cnt)
+	for _, tsk := range tsks[:utils.Min(cnt, len(tsks))] {
+		id := attemptID(tsk.id, tsk.attempt)
+		res = append(res, lostAttempt{wrkrID: tsk.wrkrID, attemptID: id})
+		delete(m.attempts, id)
+		delete(m.wrkr2tsk[tsk.wrkrID], tsk.id)
+		tsk.wrkrID = invalidWorkerID
+		tsk.status = failed
+	}
+	return res
+}
+
+// exhaustedTask returns the ID of a task that failed at least maxFailures
+// times, if any. The boolean return value is false if no such task exists
+func (m *tasksManager) exhaustedTask(maxFailures int) (int32, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	for tskID, tsk := range m.tsks {
+		if tsk.failures >= maxFailures {
+			return tskID, true
+		}
+	}
+	return 0, false
+}
+
+// counters returns the counters of the completed tasks, aggregated by name
+func (m *tasksManager) counters() map[string]int64 {
+	m.Lock()
+	defer m.Unlock()
+
+	res := make(map[string]int64)
+	for _, tsk := range m.tsks {
+		res["failed_attempts"] += int64(tsk.failures)
+		if tsk.status != done {
+			continue
+		}
+
+		if tsk.method == mapTask {
+			res["map_tasks"]++
+			res["map_input_bytes"] += tsk.progress.BytesRead
+			res["map_input_records"] += tsk.progress.Records
+		} else {
+			res["reduce_tasks"]++
+			res["reduce_input_keys"] += tsk.progress.Keys
+		}
 	}
 	return res
 }
@@ -120,9 +339,15 @@ func (m *tasksManager) updatedTasksStatus(
 // updateTaskStatus updates the status of a task associated with a worker. Both
 // the task and the worker must be valid; additionally, the task must be
 // associated with the worker. If these conditions are not satisfied, this
-// method will panic
-func (m *tasksManager) updateTaskStatus(tskStatus workers.Status,
-	tskID int32) {
+// method will panic. Updates from attempts other than the latest one, such as
+// attempts running on workers believed to have failed, and updates received
+// once the manager is closed are ignored. The output of a successful attempt
+// is committed by commit, if not nil, before the task is marked as done; the
+// attempt fails if its output cannot be committed. The method returns true if
+// the update has been accepted, so that the output of at most one attempt per
+// task is committed
+func (m *tasksManager) updateTaskStatus(reply *workers.TaskReply,
+	tskID, attempt int32, commit func() error) bool {
 	m.Lock()
 	defer m.Unlock()
 
@@ -130,15 +355,41 @@ func (m *tasksManager) updateTaskStatus(tskStatus workers.Status,
 		panic(fmt.Sprintf("updatetaskstatus: task %d not found", tskID))
 	}
 
-	if tskStatus == workers.SUCCESS {
+	delete(m.attempts, attemptID(tskID, attempt))
+	if m.closed || m.tsks[tskID].attempt != attempt ||
+		m.tsks[tskID].status != inProgress {
+		return false
+	}
+
+	m.tsks[tskID].progress = reply.Counters
+	if reply.Status == workers.SUCCESS && commit != nil {
+		if err := commit(); err != nil {
+			reply.Status = workers.FAILED
+			reply.Error = fmt.Sprintf("commit: %v", err)
+		}
+	}
+	if reply.Status == workers.SUCCESS {
 		m.tsks[tskID].status = done
-		if m.tsks[tskID].method == reduceTask {
+		if m.final(m.tsks[tskID]) {
 			m.tskLeft--
 		}
+		if m.tsks[tskID].method == mapTask {
+			m.mapDone++
+		}
 	} else {
 		wrkrID := m.tsks[tskID].wrkrID
 		delete(m.wrkr2tsk[wrkrID], tskID)
 		m.tsks[tskID].wrkrID = invalidWorkerID
 		m.tsks[tskID].status = failed
+		m.tsks[tskID].failures++
 	}
+	return true
+}
+
+// close makes the manager ignore the updates of the attempts still running,
+// so that no attempt output is committed once the job has completed
+func (m *tasksManager) close() {
+	m.Lock()
+	defer m.Unlock()
+	m.closed = true
 }
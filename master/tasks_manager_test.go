@@ -0,0 +1,111 @@
+package master
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/giulioborghesi/mapreduce/workers"
+)
+
+func TestTasksManagerProgress(t *testing.T) {
+	m := makeTasksManager([]task{makeMapperTask(0, 0, 2, 1),
+		makeMapperTask(1, 1, 2, 1), makeReducerTask(2, 0, 2, 1)})
+	a0 := m.assignWorkerToTask(0, 0)
+	a1 := m.assignWorkerToTask(1, 1)
+
+	// Progress is recorded for the running attempts only
+	m.updateProgress(map[string]workers.Progress{
+		attemptID(0, a0):     {BytesRead: 10, BytesTotal: 20, Records: 2},
+		attemptID(0, a0+1):   {BytesRead: 99},
+		attemptID(1, a1):     {BytesRead: 5, BytesTotal: 20, Records: 1},
+		"attempt_unknown_id": {BytesRead: 99}})
+	if p := m.task(0).progress; p.BytesRead != 10 || p.Records != 2 {
+		t.Errorf("Progress incorrect, got: %v", p)
+	}
+
+	// Attempts are forgotten when they complete or their worker fails
+	m.updateTaskStatus(&workers.TaskReply{Status: workers.SUCCESS,
+		Counters: workers.Progress{BytesRead: 20, Records: 4}}, 0, a0, nil)
+	m.updatedTasksStatus(map[int32]workerStatus{0: healthy, 1: dead})
+	if len(m.attempts) != 0 {
+		t.Errorf("Finished attempts still tracked: %v", m.attempts)
+	}
+	m.updateProgress(map[string]workers.Progress{
+		attemptID(1, a1): {BytesRead: 15}})
+	if m.task(1).status != idle || m.task(1).progress.BytesRead != 5 {
+		t.Errorf("Progress of lost attempt recorded: %v", m.task(1).progress)
+	}
+
+	// Counters only aggregate the completed tasks
+	c := m.counters()
+	if c["map_tasks"] != 1 || c["map_input_bytes"] != 20 ||
+		c["map_input_records"] != 4 {
+		t.Errorf("Counters incorrect, got: %v", c)
+	}
+}
+
+func TestTasksManagerMapOnly(t *testing.T) {
+	m := makeTasksManager([]task{makeMapperTask(0, 0, 2, 0),
+		makeMapperTask(1, 1, 2, 0)})
+	if !m.mapOnly || m.tasksLeft() != 2 || !m.final(m.task(0)) {
+		t.Fatalf("Map tasks of a map-only job should produce its output")
+	}
+
+	// The job completes when all its map tasks have completed
+	for tskID := int32(0); tskID < 2; tskID++ {
+		attempt := m.assignWorkerToTask(0, tskID)
+		m.updateTaskStatus(&workers.TaskReply{Status: workers.SUCCESS},
+			tskID, attempt, nil)
+	}
+	if m.tasksLeft() != 0 || m.mapTasksDone() != 2 {
+		t.Errorf("Tasks left incorrect, got: %d, want: 0", m.tasksLeft())
+	}
+
+	// Map tasks lost with their worker must run again
+	m.updatedTasksStatus(map[int32]workerStatus{0: dead})
+	if m.tasksLeft() != 2 {
+		t.Errorf("Tasks left incorrect, got: %d, want: 2", m.tasksLeft())
+	}
+}
+
+func TestTasksManagerCommit(t *testing.T) {
+	m := makeTasksManager([]task{makeMapperTask(0, 0, 1, 0)})
+	commits := 0
+	commit := func() error {
+		commits++
+		return nil
+	}
+
+	// Attempts whose output cannot be committed fail
+	a0 := m.assignWorkerToTask(0, 0)
+	reply := &workers.TaskReply{Status: workers.SUCCESS}
+	if !m.updateTaskStatus(reply, 0, a0, func() error {
+		return fmt.Errorf("disk full")
+	}) || reply.Status != workers.FAILED || m.task(0).failures != 1 {
+		t.Fatalf("Attempt not failed, got: %v", reply)
+	}
+
+	// Only the latest attempt is committed, and only once
+	m.updatedTasksStatus(map[int32]workerStatus{})
+	a1 := m.assignWorkerToTask(1, 0)
+	m.updatedTasksStatus(map[int32]workerStatus{0: healthy, 1: dead})
+	a2 := m.assignWorkerToTask(0, 0)
+	if m.updateTaskStatus(&workers.TaskReply{Status: workers.SUCCESS}, 0, a1,
+		commit) {
+		t.Errorf("Stale attempt accepted")
+	}
+	if !m.updateTaskStatus(&workers.TaskReply{Status: workers.SUCCESS}, 0,
+		a2, commit) || m.updateTaskStatus(&workers.TaskReply{
+		Status: workers.SUCCESS}, 0, a2, commit) || commits != 1 {
+		t.Errorf("Commits incorrect, got: %d, want: 1", commits)
+	}
+
+	// Closed managers accept no update
+	m.updatedTasksStatus(map[int32]workerStatus{0: dead})
+	a3 := m.assignWorkerToTask(1, 0)
+	m.close()
+	if m.updateTaskStatus(&workers.TaskReply{Status: workers.SUCCESS}, 0, a3,
+		commit) || commits != 1 {
+		t.Errorf("Update accepted by closed manager")
+	}
+}
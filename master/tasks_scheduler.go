@@ -1,45 +1,159 @@
 package master
 
 import (
+	"math"
+	"net"
 	"sync"
+	"time"
 
 	"github.com/giulioborghesi/mapreduce/utils"
 )
 
+const (
+	// nodeLocal means that a task input is stored on the worker host
+	nodeLocal = iota
+	// rackLocal means that a task input is stored on the worker rack
+	rackLocal
+	// offRack means that a task input is stored on a different rack, or that
+	// the task has no locality preference
+	offRack
+)
+
+// pendingTask represents a task waiting to be scheduled, together with the
+// job it belongs to, the hosts storing its input and the time at which it was
+// queued
+type pendingTask struct {
+	id       int32
+	job      string
+	priority int8
+	hosts    []string
+	since    time.Time
+}
+
+// tasksScheduler pairs tasks ready to be executed with workers that have a
+// free slot of the kind required by the task. Each free slot is represented by
+// an entry in the slots stack of the corresponding kind, so that a worker with
+// several free slots appears multiple times in the stack. Free slots are
+// offered to jobs in the order decided by the scheduling policy. Tasks with
+// locality preferences are delayed for up to a locality wait per locality
+// level in the hope that a slot on a node-local, then rack-local, worker
+// becomes free; in the meantime, the slot is offered to the next job
 type tasksScheduler struct {
-	ws utils.Stack
-	tq utils.Queue
-	cv *sync.Cond
+	ws     [slotKinds][]int32
+	tq     [slotKinds][]pendingTask
+	jobs   map[string]*jobShare
+	order  []*jobShare
+	policy schedulingPolicy
+	hosts  map[int32]string
+	racks  map[string]string
+	wait   time.Duration
+	cv     *sync.Cond
 	sync.Mutex
 }
 
-func makeTasksScheduler(wrkrs []worker, tsks []task) *tasksScheduler {
+// makeTasksScheduler creates a new tasksScheduler object. The topology maps
+// hosts to racks, while wait is the time a task waits for a slot at each
+// locality level before accepting a slot at the next level
+func makeTasksScheduler(wrkrs []worker, tsks []task, policy schedulingPolicy,
+	topology map[string]string, wait time.Duration) *tasksScheduler {
 	ts := new(tasksScheduler)
 	ts.cv = sync.NewCond(new(sync.Mutex))
+	ts.jobs = make(map[string]*jobShare)
+	ts.policy = policy
+	ts.hosts = make(map[int32]string)
+	ts.racks = topology
+	ts.wait = wait
 	for _, wrkr := range wrkrs {
-		ts.addWorker(wrkr.id)
-
+		ts.hosts[wrkr.id] = hostName(wrkr.addr)
 	}
 
-	for _, tsk := range tsks {
-		ts.addTask(tsk.id, tsk.priority)
+	for idx := range tsks {
+		ts.addTask(&tsks[idx])
 	}
 	return ts
 }
 
-// addTask adds a task to the tasks queue
-func (ts *tasksScheduler) addTask(id int32, priority int8) {
+// addJob registers a job and the pool it belongs to with the scheduler. Jobs
+// are registered in submission order
+func (ts *tasksScheduler) addJob(id, pool string) {
+	ts.Lock()
+	defer ts.Unlock()
+	ts.job(id).pool = pool
+}
+
+// job returns the share of the job with the specified ID, registering the
+// job in the default pool if needed. The caller must hold the scheduler lock
+func (ts *tasksScheduler) job(id string) *jobShare {
+	if js, ok := ts.jobs[id]; ok {
+		return js
+	}
+
+	js := &jobShare{id: id, pool: defaultPool}
+	ts.jobs[id] = js
+	ts.order = append(ts.order, js)
+	return js
+}
+
+// removeJob forgets a job and drops its tasks waiting to be scheduled
+func (ts *tasksScheduler) removeJob(id string) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	if _, ok := ts.jobs[id]; !ok {
+		return
+	}
+	delete(ts.jobs, id)
+	for idx, js := range ts.order {
+		if js.id == id {
+			ts.order = append(ts.order[:idx], ts.order[idx+1:]...)
+			break
+		}
+	}
+
+	for slot := range ts.tq {
+		tq := ts.tq[slot][:0]
+		for _, tsk := range ts.tq[slot] {
+			if tsk.job != id {
+				tq = append(tq, tsk)
+			}
+		}
+		ts.tq[slot] = tq
+	}
+}
+
+// addTask adds a task to the queue of the tasks requiring a slot of its kind.
+// Tasks are ordered by priority and then by insertion time. The hosts storing
+// the task input, if any, are used as locality preferences
+func (ts *tasksScheduler) addTask(t *task) {
 	ts.Lock()
 	defer ts.Unlock()
-	ts.tq.Push(utils.QueueItem{ID: id, Priority: priority})
+
+	tsk := pendingTask{id: t.id, job: t.job, priority: t.priority,
+		since: time.Now()}
+	for _, host := range t.hosts {
+		tsk.hosts = append(tsk.hosts, hostName(host))
+	}
+
+	slot := t.slot()
+	tq := ts.tq[slot]
+	pos := len(tq)
+	for pos > 0 && tq[pos-1].priority > tsk.priority {
+		pos--
+	}
+	tq = append(tq, pendingTask{})
+	copy(tq[pos+1:], tq[pos:])
+	tq[pos] = tsk
+	ts.tq[slot] = tq
+	ts.job(t.job).pending[slot]++
 	ts.cv.Signal()
 }
 
-// addWorker adds a worker to the available workers stack
-func (ts *tasksScheduler) addWorker(id int32) {
+// addWorker adds a free slot of the specified kind for a worker to the
+// available slots stack
+func (ts *tasksScheduler) addWorker(id int32, slot slotKind) {
 	ts.Lock()
 	defer ts.Unlock()
-	ts.ws.Push(id)
+	ts.ws[slot] = append(ts.ws[slot], id)
 	ts.cv.Signal()
 }
 
@@ -48,20 +162,199 @@ func (ts *tasksScheduler) addWorker(id int32) {
 func (ts *tasksScheduler) hasReadyTask() bool {
 	ts.Lock()
 	defer ts.Unlock()
-	return ts.tq.Len() > 0 && ts.ws.Len() > 0
+	_, _, _, ok := ts.match(time.Now())
+	return ok
 }
 
-// nextTask returns the ID of the next task to be executed and the ID of the
-// worker where such task should be executed.
-func (ts *tasksScheduler) nextTask() (int32, int32) {
+// nextTask returns the ID of the next task to be executed, the ID of the
+// worker where such task should be executed and the kind of slot used. The
+// slot is accounted to the task job until release is called
+func (ts *tasksScheduler) nextTask() (int32, int32, slotKind) {
 	ts.Lock()
 	defer ts.Unlock()
 
-	if ts.tq.Len() == 0 || ts.ws.Len() == 0 {
+	slot, tIdx, wIdx, ok := ts.match(time.Now())
+	if !ok {
 		panic("nexttask: no task ready to be executed")
 	}
 
-	tID := ts.tq.Pop().(utils.QueueItem).ID
-	wID := ts.ws.Pop().(int32)
-	return tID, wID
+	tsk := ts.tq[slot][tIdx]
+	wID := ts.ws[slot][wIdx]
+	ts.tq[slot] = append(ts.tq[slot][:tIdx], ts.tq[slot][tIdx+1:]...)
+	ts.ws[slot] = append(ts.ws[slot][:wIdx], ts.ws[slot][wIdx+1:]...)
+	js := ts.job(tsk.job)
+	js.pending[slot]--
+	js.running[slot]++
+	return tsk.id, wID, slot
+}
+
+// release records that a job does not use a slot of the specified kind
+// anymore. Slots used by jobs that have been removed are ignored
+func (ts *tasksScheduler) release(job string, slot slotKind) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	if js, ok := ts.jobs[job]; ok && js.running[slot] > 0 {
+		js.running[slot]--
+	}
+}
+
+// match finds the first task for which a free slot with an acceptable
+// locality level exists. Map slots are considered first. Jobs are considered
+// in the order decided by the scheduling policy, and the tasks of a job in
+// queue order. It returns the slot kind and the positions of the task and of
+// the slot in their queues
+func (ts *tasksScheduler) match(now time.Time) (slotKind, int, int, bool) {
+	for slot := slotKind(0); slot < slotKinds; slot++ {
+		if len(ts.ws[slot]) == 0 || len(ts.tq[slot]) == 0 {
+			continue
+		}
+
+		for _, js := range ts.orderedJobs(slot) {
+			for tIdx, tsk := range ts.tq[slot] {
+				if tsk.job != js.id {
+					continue
+				}
+
+				allowed := ts.allowedLevel(tsk, now)
+				if wIdx, level := ts.bestSlot(tsk, slot); level <= allowed {
+					return slot, tIdx, wIdx, true
+				}
+			}
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// total returns the number of slots of the specified kind, either free or
+// used by a job
+func (ts *tasksScheduler) total(slot slotKind) int {
+	res := len(ts.ws[slot])
+	for _, js := range ts.order {
+		res += js.running[slot]
+	}
+	return res
+}
+
+// orderedJobs returns the jobs with tasks waiting for a slot of the specified
+// kind, in the order in which they should be offered a free slot
+func (ts *tasksScheduler) orderedJobs(slot slotKind) []*jobShare {
+	shares := ts.policy.shares(ts.order, slot, ts.total(slot))
+	jobs := make([]*jobShare, 0, len(ts.order))
+	waiting := make([]float64, 0, len(ts.order))
+	for idx, js := range ts.order {
+		if js.pending[slot] > 0 {
+			jobs = append(jobs, js)
+			waiting = append(waiting, shares[idx])
+		}
+	}
+	return orderJobs(jobs, waiting, slot)
+}
+
+// preemptions returns the number of slots of the specified kind that each
+// job above its share should give up, so that jobs that have been below
+// their share for at least timeout can run their tasks. No slot is preempted
+// while free slots exist
+func (ts *tasksScheduler) preemptions(slot slotKind,
+	timeout time.Duration) map[string]int {
+	ts.Lock()
+	defer ts.Unlock()
+
+	now := time.Now()
+	shares := ts.policy.shares(ts.order, slot, ts.total(slot))
+	needed := 0
+	starving := make([]*jobShare, 0)
+	for idx, js := range ts.order {
+		below := int(math.Floor(shares[idx]+1e-9)) - js.running[slot]
+		if below <= 0 || js.pending[slot] == 0 {
+			js.starved[slot] = time.Time{}
+			continue
+		}
+
+		if js.starved[slot].IsZero() {
+			js.starved[slot] = now
+		}
+		if now.Sub(js.starved[slot]) >= timeout {
+			needed += utils.Min(below, js.pending[slot])
+			starving = append(starving, js)
+		}
+	}
+	if needed == 0 || len(ts.ws[slot]) > 0 {
+		return nil
+	}
+
+	// Preempt the slots of the most recently submitted jobs first, and give
+	// the tasks of the starving jobs time to be scheduled
+	res := make(map[string]int)
+	for idx := len(ts.order) - 1; idx >= 0 && needed > 0; idx-- {
+		js := ts.order[idx]
+		above := js.running[slot] - int(math.Ceil(shares[idx]-1e-9))
+		if above > 0 {
+			res[js.id] = utils.Min(above, needed)
+			needed -= res[js.id]
+		}
+	}
+	for _, js := range starving {
+		js.starved[slot] = now
+	}
+	return res
+}
+
+// allowedLevel returns the worst locality level a task accepts, based on how
+// long the task has been waiting
+func (ts *tasksScheduler) allowedLevel(tsk pendingTask, now time.Time) int {
+	if len(tsk.hosts) == 0 {
+		return offRack
+	}
+
+	waited := now.Sub(tsk.since)
+	switch {
+	case waited < ts.wait:
+		return nodeLocal
+	case waited < 2*ts.wait:
+		return rackLocal
+	}
+	return offRack
+}
+
+// bestSlot returns the position of the free slot of the specified kind with
+// the best locality level for a task, together with that level. Among slots
+// with the same level, the most recently freed one is preferred
+func (ts *tasksScheduler) bestSlot(tsk pendingTask, slot slotKind) (int, int) {
+	best, bestLevel := -1, offRack+1
+	for wIdx := len(ts.ws[slot]) - 1; wIdx >= 0; wIdx-- {
+		level := ts.level(tsk, ts.hosts[ts.ws[slot][wIdx]])
+		if level < bestLevel {
+			best, bestLevel = wIdx, level
+		}
+		if level == nodeLocal {
+			break
+		}
+	}
+	return best, bestLevel
+}
+
+// level returns the locality level of a task input with respect to a host
+func (ts *tasksScheduler) level(tsk pendingTask, host string) int {
+	rack := ts.racks[host]
+	level := offRack
+	for _, h := range tsk.hosts {
+		if h == host {
+			return nodeLocal
+		}
+		if rack != "" && ts.racks[h] == rack {
+			level = rackLocal
+		}
+	}
+	return level
+}
+
+// hostName returns the host part of an address. The address is returned as is
+// if it does not contain a port
+func hostName(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
 }
@@ -0,0 +1,47 @@
+package master
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTasksSchedulerLocality(t *testing.T) {
+	// Create three workers on two racks and a map task whose input is
+	// stored on the host of the second worker
+	wrkrs := []worker{{id: 0, addr: "a:1234"}, {id: 1, addr: "b:1234"},
+		{id: 2, addr: "c:1234"}}
+	tsk := makeMapperTask(0, 0, 1, 1)
+	tsk.hosts = []string{"b"}
+	topology := map[string]string{"a": "r1", "b": "r2", "c": "r2"}
+	ts := makeTasksScheduler(wrkrs, []task{tsk}, fifoPolicy{}, topology,
+		time.Hour)
+
+	// Task should not be scheduled on an off-rack worker before the wait
+	ts.addWorker(0, mapSlot)
+	if ts.hasReadyTask() {
+		t.Fatalf("Task scheduled on off-rack worker before locality wait")
+	}
+
+	// Task should be scheduled on a node-local worker right away
+	ts.addWorker(2, mapSlot)
+	ts.addWorker(1, mapSlot)
+	tskID, wrkrID, _ := ts.nextTask()
+	if tskID != 0 || wrkrID != 1 {
+		t.Errorf("Task scheduled incorrectly, got: (%d, %d), want: (%d, %d)",
+			tskID, wrkrID, 0, 1)
+	}
+
+	// Task should be scheduled on a rack-local worker after the wait
+	ts.addTask(&tsk)
+	ts.tq[mapSlot][0].since = time.Now().Add(-90 * time.Minute)
+	if _, wrkrID, _ := ts.nextTask(); wrkrID != 2 {
+		t.Errorf("Worker incorrect, got: %d, want: %d", wrkrID, 2)
+	}
+
+	// Task should be scheduled on any worker after twice the wait
+	ts.addTask(&tsk)
+	ts.tq[mapSlot][0].since = time.Now().Add(-3 * time.Hour)
+	if _, wrkrID, _ := ts.nextTask(); wrkrID != 0 {
+		t.Errorf("Worker incorrect, got: %d, want: %d", wrkrID, 0)
+	}
+}
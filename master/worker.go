@@ -2,9 +2,11 @@ package master
 
 // worker represents a MapReduce worker. A MapReduce worker is uniquely
 // identified by a worker ID and by a unique address, and its status is
-// described by a WorkerStatus object
+// described by a WorkerStatus object. The number of tasks of each kind a
+// worker can run concurrently is advertised by the worker at registration
 type worker struct {
 	id     int32
 	addr   string
+	slots  [slotKinds]int
 	status workerStatus
 }
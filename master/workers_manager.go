@@ -1,12 +1,12 @@
 package master
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
-	"github.com/giulioborghesi/mapreduce/utils"
-
 	"github.com/giulioborghesi/mapreduce/workers"
 )
 
@@ -44,6 +44,18 @@ func (m *workersManager) activeWorkers() int {
 	return m.activeCnt
 }
 
+// isActive returns true if the worker with the specified ID is believed to be
+// healthy, and false otherwise. This method will panic if the specified worker
+// ID is invalid
+func (m *workersManager) isActive(id int32) bool {
+	if _, ok := m.wrkrs[id]; !ok {
+		panic(fmt.Sprintf("isactive: invalid worker id: %d", id))
+	}
+	m.Lock()
+	defer m.Unlock()
+	return m.wrkrs[id].status == healthy
+}
+
 // reportFailedWorker should be used by clients to report failed workers. This
 // method will panic if the specified worker ID is invalid
 func (m *workersManager) reportFailedWorker(id int32) {
@@ -54,14 +66,17 @@ func (m *workersManager) reportFailedWorker(id int32) {
 	defer m.Unlock()
 
 	if m.wrkrs[id].status == healthy {
+		slog.Warn("worker failed", "worker", m.wrkrs[id].addr)
 		m.activeCnt--
 	}
 	m.wrkrs[id].status = dead
 }
 
 // updatedWorkersStatus updates the status of each worker and returns a map
-// from worker ID to worker status
-func (m *workersManager) updatedWorkersStatus() map[int32]workerStatus {
+// from worker ID to worker status. Heartbeat messages are sent through the
+// clients pool
+func (m *workersManager) updatedWorkersStatus(
+	p *clientsPool) map[int32]workerStatus {
 	chans := make(map[int32]chan workerStatus)
 	for id := range m.wrkrs {
 		wrkr := m.wrkrs[id]
@@ -72,15 +87,12 @@ func (m *workersManager) updatedWorkersStatus() map[int32]workerStatus {
 		rchn := make(chan workerStatus)
 		chans[id] = rchn
 		go func() {
-			client, err := utils.DialHTTP("tcp", wrkr.addr,
+			ctx, cancel := context.WithTimeout(context.Background(),
 				statusDeadlineInMs*time.Millisecond)
-			if err != nil {
-				rchn <- dead
-				return
-			}
+			defer cancel()
 
-			err = client.Call(statusTask, workers.Void{}, new(workers.Void))
-			client.Close()
+			err := p.call(ctx, wrkr.id, statusTask, workers.Void{},
+				new(workers.Void))
 			if err != nil {
 				rchn <- dead
 				return
@@ -108,6 +120,9 @@ func (m *workersManager) updatedWorkersStatus() map[int32]workerStatus {
 		}
 
 		if status := newStatus[id]; status == dead {
+			if m.wrkrs[id].status == healthy {
+				slog.Warn("worker failed", "worker", m.wrkrs[id].addr)
+			}
 			res[id] = dead
 			m.wrkrs[id].status = dead
 		}
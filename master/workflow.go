@@ -0,0 +1,367 @@
+package master
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/workers"
+)
+
+// WorkflowStep describes a job of a workflow and the steps it depends on. The
+// output directories of the steps a job depends on are appended to the job
+// inputs
+type WorkflowStep struct {
+	Name      string
+	Job       JobSpec
+	DependsOn []string
+}
+
+// WorkflowSpec describes a workflow, that is a DAG of jobs. Steps without an
+// output directory write their output to a subdirectory of Dir named after
+// the step. If Resume is true, steps whose output is already marked as
+// complete are not executed again, and the partial output left by the other
+// steps is cleared before they run
+type WorkflowSpec struct {
+	Name   string
+	Dir    string
+	Resume bool
+	Steps  []WorkflowStep
+}
+
+// StepStatus describes the status of a workflow step. JobID is empty until
+// the step job is submitted
+type StepStatus struct {
+	Name, State, JobID string
+}
+
+// WorkflowStatus describes the status of a workflow, as reported to clients
+type WorkflowStatus struct {
+	ID, Name, State string
+	Error           string
+	Steps           []StepStatus
+}
+
+// AddStep appends a step running the specified job to a workflow. The step
+// depends on the steps with the specified names
+func (wf *WorkflowSpec) AddStep(name string, spec JobSpec, deps ...string) {
+	wf.Steps = append(wf.Steps, WorkflowStep{Name: name, Job: spec,
+		DependsOn: deps})
+}
+
+// step represents a workflow step managed by the master. Skipped steps are
+// steps whose output was already complete when the workflow was submitted
+type step struct {
+	name    string
+	spec    JobSpec
+	deps    []int
+	jobID   string
+	state   jobState
+	skipped bool
+}
+
+// workflow represents a workflow managed by the master. Steps are stored in
+// topological order
+type workflow struct {
+	id    string
+	spec  WorkflowSpec
+	state jobState
+	err   string
+	steps []*step
+	done  chan workers.Void
+}
+
+// makeWorkflow validates a workflow specification and creates the workflow
+// it describes. The jobs of the workflow steps are completed with the output
+// directories of the steps and of their dependencies
+func makeWorkflow(id string, spec WorkflowSpec) (*workflow, error) {
+	if len(spec.Steps) == 0 {
+		return nil, fmt.Errorf("makeworkflow: workflow has no steps")
+	}
+
+	// Index steps by name and check dependencies
+	idxs := make(map[string]int)
+	for idx, s := range spec.Steps {
+		if s.Name == "" {
+			return nil, fmt.Errorf("makeworkflow: step %d has no name", idx)
+		}
+		if _, ok := idxs[s.Name]; ok {
+			return nil, fmt.Errorf("makeworkflow: duplicate step: %s", s.Name)
+		}
+		idxs[s.Name] = idx
+	}
+	for _, s := range spec.Steps {
+		for _, dep := range s.DependsOn {
+			if _, ok := idxs[dep]; !ok {
+				return nil, fmt.Errorf("makeworkflow: step %s depends on "+
+					"unknown step: %s", s.Name, dep)
+			}
+		}
+	}
+
+	order, err := sortSteps(spec.Steps, idxs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the steps in topological order and complete their jobs
+	wf := &workflow{id: id, spec: spec, state: jobQueued,
+		done: make(chan workers.Void)}
+	pos := make(map[string]int)
+	for _, idx := range order {
+		s := spec.Steps[idx]
+		job := s.Job
+		job.Inputs = append([]string(nil), job.Inputs...)
+		if job.Name == "" {
+			job.Name = s.Name
+		}
+		if job.Output == "" {
+			if spec.Dir == "" {
+				return nil, fmt.Errorf("makeworkflow: step %s has no output "+
+					"directory", s.Name)
+			}
+			job.Output = filepath.Join(spec.Dir, s.Name)
+		}
+
+		st := &step{name: s.Name, state: jobQueued}
+		for _, dep := range s.DependsOn {
+			st.deps = append(st.deps, pos[dep])
+			job.Inputs = append(job.Inputs, wf.steps[pos[dep]].spec.Output)
+		}
+		if err := validateJobSpec(job); err != nil {
+			return nil, fmt.Errorf("makeworkflow: step %s: %v", s.Name, err)
+		}
+
+		st.spec = job
+		pos[s.Name] = len(wf.steps)
+		wf.steps = append(wf.steps, st)
+	}
+	return wf, nil
+}
+
+// sortSteps sorts the steps of a workflow in topological order and returns
+// their indices. An error is returned if the dependencies contain a cycle
+func sortSteps(steps []WorkflowStep, idxs map[string]int) ([]int, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	res := make([]int, 0, len(steps))
+	marks := make([]int, len(steps))
+	var visit func(idx int) error
+	visit = func(idx int) error {
+		switch marks[idx] {
+		case visiting:
+			return fmt.Errorf("sortsteps: dependency cycle at step %s",
+				steps[idx].Name)
+		case visited:
+			return nil
+		}
+
+		marks[idx] = visiting
+		for _, dep := range steps[idx].DependsOn {
+			if err := visit(idxs[dep]); err != nil {
+				return err
+			}
+		}
+		marks[idx] = visited
+		res = append(res, idx)
+		return nil
+	}
+
+	for idx := range steps {
+		if err := visit(idx); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// ready returns true if all the dependencies of a step have completed
+// successfully. The caller must hold the coordinator lock
+func (wf *workflow) ready(st *step) bool {
+	for _, dep := range st.deps {
+		if wf.steps[dep].state != jobSucceeded {
+			return false
+		}
+	}
+	return true
+}
+
+// status returns the workflow status. The caller must hold the coordinator
+// lock
+func (wf *workflow) status() WorkflowStatus {
+	s := WorkflowStatus{ID: wf.id, Name: wf.spec.Name,
+		State: wf.state.String(), Error: wf.err}
+	for _, st := range wf.steps {
+		state := st.state.String()
+		if st.skipped {
+			state = "skipped"
+		}
+		s.Steps = append(s.Steps, StepStatus{Name: st.name, State: state,
+			JobID: st.jobID})
+	}
+	return s
+}
+
+// SubmitWorkflow starts a new workflow and returns its ID. The workflow
+// steps are submitted as jobs as soon as the steps they depend on have
+// completed successfully
+func (c *Coordinator) SubmitWorkflow(spec WorkflowSpec) (string, error) {
+	c.mu.Lock()
+	c.nextWfID++
+	id := fmt.Sprintf("workflow_%04d", c.nextWfID)
+	c.mu.Unlock()
+
+	wf, err := makeWorkflow(id, spec)
+	if err != nil {
+		return "", err
+	}
+
+	// Skip the steps whose output is complete when resuming a workflow
+	if spec.Resume {
+		for _, st := range wf.steps {
+			if formats.OutputComplete(st.spec.Output) {
+				st.state, st.skipped = jobSucceeded, true
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.workflows[id] = wf
+	wf.state = jobRunning
+	c.mu.Unlock()
+
+	slog.Info("workflow submitted", "workflow", id, "name", spec.Name)
+	go c.runWorkflow(wf)
+	return id, nil
+}
+
+// ResumeWorkflow submits again a failed workflow, skipping the steps that
+// completed successfully, and returns the ID of the new workflow
+func (c *Coordinator) ResumeWorkflow(id string) (string, error) {
+	c.mu.Lock()
+	wf, ok := c.workflows[id]
+	if !ok {
+		c.mu.Unlock()
+		return "", fmt.Errorf("resumeworkflow: unknown workflow: %s", id)
+	}
+	if wf.state != jobFailed {
+		c.mu.Unlock()
+		return "", fmt.Errorf("resumeworkflow: workflow %s is %s", id,
+			wf.state)
+	}
+	spec := wf.spec
+	c.mu.Unlock()
+
+	spec.Resume = true
+	return c.SubmitWorkflow(spec)
+}
+
+// WorkflowStatus returns the status of the workflow with the specified ID
+func (c *Coordinator) WorkflowStatus(id string) (WorkflowStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wf, ok := c.workflows[id]
+	if !ok {
+		return WorkflowStatus{}, fmt.Errorf("workflowstatus: unknown "+
+			"workflow: %s", id)
+	}
+	return wf.status(), nil
+}
+
+// WaitWorkflow blocks until the workflow with the specified ID completes and
+// returns its final status
+func (c *Coordinator) WaitWorkflow(id string) (WorkflowStatus, error) {
+	c.mu.Lock()
+	wf, ok := c.workflows[id]
+	c.mu.Unlock()
+	if !ok {
+		return WorkflowStatus{}, fmt.Errorf("waitworkflow: unknown "+
+			"workflow: %s", id)
+	}
+
+	<-wf.done
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return wf.status(), nil
+}
+
+// runWorkflow submits the jobs of the workflow steps whose dependencies have
+// completed and waits for them to complete. No new step is submitted once a
+// step fails, and the workflow fails when the running steps have completed
+func (c *Coordinator) runWorkflow(wf *workflow) {
+	type stepResult struct {
+		st     *step
+		status JobStatus
+	}
+
+	results := make(chan stepResult)
+	running := 0
+	for {
+		// Find the steps ready to be executed
+		c.mu.Lock()
+		ready := make([]*step, 0)
+		for _, st := range wf.steps {
+			if wf.err == "" && st.state == jobQueued && wf.ready(st) {
+				st.state = jobRunning
+				ready = append(ready, st)
+			}
+		}
+		c.mu.Unlock()
+
+		// Submit their jobs and wait for them asynchronously
+		for _, st := range ready {
+			jobID, err := c.submit(st.spec, wf.spec.Resume)
+			c.mu.Lock()
+			if err != nil {
+				st.state = jobFailed
+				wf.err = fmt.Sprintf("step %s: %v", st.name, err)
+				c.mu.Unlock()
+				continue
+			}
+			st.jobID = jobID
+			c.mu.Unlock()
+
+			running++
+			go func(st *step, jobID string) {
+				status, _ := c.Wait(jobID)
+				results <- stepResult{st: st, status: status}
+			}(st, jobID)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		// Record the outcome of the next step to complete
+		r := <-results
+		running--
+		c.mu.Lock()
+		if r.status.State == jobSucceeded.String() {
+			r.st.state = jobSucceeded
+		} else {
+			r.st.state = jobFailed
+			if wf.err == "" {
+				wf.err = fmt.Sprintf("step %s: job %s failed: %s",
+					r.st.name, r.st.jobID, r.status.Error)
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wf.state = jobSucceeded
+	if wf.err != "" {
+		wf.state = jobFailed
+		slog.Error("workflow failed", "workflow", wf.id, "reason", wf.err)
+	} else {
+		slog.Info("workflow completed", "workflow", wf.id)
+	}
+	close(wf.done)
+}
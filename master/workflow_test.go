@@ -0,0 +1,44 @@
+package master
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMakeWorkflow(t *testing.T) {
+	// Create a workflow whose steps are not listed in topological order
+	var spec WorkflowSpec
+	spec.Dir = "/tmp/wf"
+	spec.AddStep("join", JobSpec{Reducers: 1}, "count", "filter")
+	spec.AddStep("count", JobSpec{Inputs: []string{"a.dat"}, Reducers: 2})
+	spec.AddStep("filter", JobSpec{Inputs: []string{"b.dat"}, Reducers: 1,
+		Output: "/data/filter"}, "count")
+
+	wf, err := makeWorkflow("wf", spec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Steps should be sorted and their inputs and outputs completed
+	names := []string{}
+	for _, st := range wf.steps {
+		names = append(names, st.name)
+	}
+	if want := []string{"count", "filter", "join"}; !reflect.DeepEqual(names,
+		want) {
+		t.Errorf("Steps order incorrect, got: %v, want: %v", names, want)
+	}
+
+	join := wf.steps[2].spec
+	want := []string{"/tmp/wf/count", "/data/filter"}
+	if join.Output != "/tmp/wf/join" || !reflect.DeepEqual(join.Inputs, want) {
+		t.Errorf("Join step incorrect, got: %v -> %s, want: %v -> %s",
+			join.Inputs, join.Output, want, "/tmp/wf/join")
+	}
+
+	// Cycles should be rejected
+	spec.Steps[1].DependsOn = []string{"join"}
+	if _, err := makeWorkflow("wf", spec); err == nil {
+		t.Errorf("Dependency cycle not detected")
+	}
+}
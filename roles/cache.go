@@ -0,0 +1,25 @@
+package roles
+
+import "os"
+
+// Cache gives the tasks of a job access to the side files shipped with the
+// job, such as lookup tables. Side files are identified by their base name
+// and are stored on the local disk of the workers before any task runs
+type Cache interface {
+	// Path returns the local path of a side file
+	Path(name string) (string, error)
+	// Open opens a side file for reading
+	Open(name string) (*os.File, error)
+}
+
+// Task gives the Setup function of a program access to the resources of the
+// task executing the program: the side files of the job and its named
+// outputs. Named outputs are datasets written by a task besides its main
+// output, each stored in a subdirectory of the job output directory with
+// the name of the output
+type Task interface {
+	Cache
+	// Output returns the emitter writing key / value pairs to a named
+	// output of the job
+	Output(name string) (Emitter[string, string], error)
+}
@@ -0,0 +1,17 @@
+package roles
+
+// Emitter collects the key / value pairs generated by Map and Reduce
+// functions. Pairs are processed in the order in which they are emitted, and
+// emitting the same pair twice produces two records
+type Emitter[K, V any] interface {
+	Emit(key K, value V) error
+}
+
+// EmitterFunc is an adapter that allows the use of an ordinary function as an
+// Emitter
+type EmitterFunc[K, V any] func(key K, value V) error
+
+// Emit calls f(key, value)
+func (f EmitterFunc[K, V]) Emit(key K, value V) error {
+	return f(key, value)
+}
@@ -0,0 +1,192 @@
+package roles
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+// Job describes a MapReduce job working on native types. Map emits the
+// intermediate key / value pairs generated from an input record, while Reduce
+// emits zero, one or many output key / value pairs for the values of a group
+// of intermediate keys. Partition assigns intermediate keys to
+// Reducer tasks and defaults to a hash of the encoded key. Compare and Group
+// are the sort and grouping comparators of the intermediate keys; keys are
+// sorted by their encoding if Compare is nil, and grouped using Compare if
+// Group is nil. KeySerializer and ValueSerializer encode the intermediate
+// keys and values and default to the built-in serializers for their type.
+// Setup, if not nil, is called by each task before processing any record and
+// returns the job executed by the task, whose serializers default to those
+// of the registered job
+type Job[K comparable, V, OK, OV any] struct {
+	Map             func(rec formats.Record, out Emitter[K, V]) error
+	Reduce          func(key K, values *Values[K, V], out Emitter[OK, OV]) error
+	Partition       func(key K, parts int) int
+	Compare         func(a, b K) int
+	Group           func(a, b K) int
+	KeySerializer   Serializer[K]
+	ValueSerializer Serializer[V]
+	Setup           func(task Task) (Job[K, V, OK, OV], error)
+}
+
+// Values implements an iterator over the values of a group of intermediate
+// keys. Values are returned in key order
+type Values[K, V any] struct {
+	it     *utils.ValueIterator
+	keys   Serializer[K]
+	values Serializer[V]
+}
+
+// HasNext returns true if there exists a non-processed value for the group of
+// keys, and false otherwise
+func (vs *Values[K, V]) HasNext() bool {
+	return vs.it.HasNext()
+}
+
+// Next returns the next unprocessed value for the group of keys. The method
+// assumes that such value exists, and will panic otherwise
+func (vs *Values[K, V]) Next() (V, error) {
+	data, err := vs.it.Next()
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return vs.values.Decode([]byte(data))
+}
+
+// Key returns the key of the last value returned by Next. It differs from the
+// key of the group when a grouping comparator is used
+func (vs *Values[K, V]) Key() (K, error) {
+	return vs.keys.Decode([]byte(vs.it.ValueKey()))
+}
+
+// RegisterJob makes a job working on native types available to jobs under the
+// specified name. This function will panic if a program with the same name is
+// already registered or if the job has no Map function
+func RegisterJob[K comparable, V, OK, OV any](name string,
+	job Job[K, V, OK, OV]) {
+	if job.Map == nil {
+		panic(fmt.Sprintf("registerjob: job %s has no map function", name))
+	}
+	if job.KeySerializer == nil {
+		job.KeySerializer = defaultKeySerializer[K]()
+	}
+	if job.ValueSerializer == nil {
+		job.ValueSerializer = defaultSerializer[V]()
+	}
+	Register(name, job.program())
+}
+
+// program adapts a job working on native types to a program working on the
+// encoded keys and values
+func (job Job[K, V, OK, OV]) program() Program {
+	p := Program{Map: job.encodedMap, Partition: job.encodedPartition,
+		Format: job.format}
+	if job.Reduce != nil {
+		p.Reduce = job.encodedReduce
+	}
+	if job.Compare != nil {
+		p.Order.Compare = job.encodedComparator(job.Compare)
+	}
+	if job.Group != nil {
+		p.Order.Group = job.encodedComparator(job.Group)
+	}
+	if job.Setup != nil {
+		p.Setup = job.setup
+	}
+	return p
+}
+
+// setup returns the program executed by a task, created from the job
+// returned by the Setup function
+func (job Job[K, V, OK, OV]) setup(task Task) (Program, error) {
+	j, err := job.Setup(task)
+	if err != nil {
+		return Program{}, err
+	}
+	if j.Map == nil {
+		return Program{}, fmt.Errorf("setup: job has no map function")
+	}
+	if j.KeySerializer == nil {
+		j.KeySerializer = job.KeySerializer
+	}
+	if j.ValueSerializer == nil {
+		j.ValueSerializer = job.ValueSerializer
+	}
+	j.Setup = nil
+	return j.program(), nil
+}
+
+// encodedMap applies the Map function to a record and encodes the key / value
+// pairs it emits
+func (job Job[K, V, OK, OV]) encodedMap(rec formats.Record,
+	out Emitter[string, string]) error {
+	return job.Map(rec, EmitterFunc[K, V](func(key K, value V) error {
+		encodedKey, err := job.KeySerializer.Encode(key)
+		if err != nil {
+			return err
+		}
+		encodedValue, err := job.ValueSerializer.Encode(value)
+		if err != nil {
+			return err
+		}
+		return out.Emit(string(encodedKey), string(encodedValue))
+	}))
+}
+
+// encodedReduce decodes the key of a group of values, applies the Reduce
+// function and formats the key / value pairs it emits as text
+func (job Job[K, V, OK, OV]) encodedReduce(key string, it *utils.ValueIterator,
+	out Emitter[string, string]) error {
+	k, err := job.KeySerializer.Decode([]byte(key))
+	if err != nil {
+		return err
+	}
+
+	values := &Values[K, V]{it: it, keys: job.KeySerializer,
+		values: job.ValueSerializer}
+	return job.Reduce(k, values, EmitterFunc[OK, OV](func(key OK,
+		value OV) error {
+		return out.Emit(fmt.Sprint(key), fmt.Sprint(value))
+	}))
+}
+
+// encodedPartition assigns an encoded key to a partition
+func (job Job[K, V, OK, OV]) encodedPartition(key string, parts int) int {
+	if job.Partition != nil {
+		if k, err := job.KeySerializer.Decode([]byte(key)); err == nil {
+			return job.Partition(k, parts)
+		}
+	}
+	return HashPartition(key, parts)
+}
+
+// encodedComparator adapts a comparator of keys to a comparator of encoded
+// keys. Keys that cannot be decoded are compared by their encoding
+func (job Job[K, V, OK, OV]) encodedComparator(
+	cmp func(a, b K) int) func(a, b string) int {
+	return func(a, b string) int {
+		ka, errA := job.KeySerializer.Decode([]byte(a))
+		kb, errB := job.KeySerializer.Decode([]byte(b))
+		if errA != nil || errB != nil {
+			return strings.Compare(a, b)
+		}
+		return cmp(ka, kb)
+	}
+}
+
+// format decodes an encoded key / value pair and formats it as text
+func (job Job[K, V, OK, OV]) format(key, value string) (string, string,
+	error) {
+	k, err := job.KeySerializer.Decode([]byte(key))
+	if err != nil {
+		return "", "", err
+	}
+	v, err := job.ValueSerializer.Decode([]byte(value))
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprint(k), fmt.Sprint(v), nil
+}
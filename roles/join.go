@@ -0,0 +1,239 @@
+package roles
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+)
+
+// JoinKey is the intermediate key of reduce-side joins: the join key of a
+// record and the tag of the job input the record was read from
+type JoinKey struct {
+	Key, Tag string
+}
+
+// TaggedValue is an intermediate value of reduce-side joins, tagged with the
+// job input it was read from
+type TaggedValue struct {
+	Tag, Value string
+}
+
+// ReduceSideJoin describes a join of the tagged inputs of a job performed by
+// the Reducer tasks. Extract returns the join key and the value of an input
+// record, and false if the record does not take part in the join. Join
+// receives the tagged values of a join key sorted by tag, so that the values
+// of the input with the smallest tag, ideally the smallest input, can be
+// buffered while iterating over the values of the other inputs. Join
+// defaults to the inner join of the Inputs tagged inputs of the job, two if
+// zero
+type ReduceSideJoin struct {
+	Extract func(rec formats.Record) (string, string, bool)
+	Join    func(key string, values *Values[JoinKey, TaggedValue],
+		out Emitter[string, string]) error
+	Inputs int
+}
+
+// Job returns a job performing the join. Intermediate keys are partitioned
+// and grouped by join key, and sorted by join key and tag
+func (j ReduceSideJoin) Job() Job[JoinKey, TaggedValue, string, string] {
+	join := j.Join
+	if join == nil {
+		inputs := j.Inputs
+		if inputs == 0 {
+			inputs = 2
+		}
+		join = InnerJoin(inputs)
+	}
+
+	return Job[JoinKey, TaggedValue, string, string]{
+		Map: func(rec formats.Record,
+			out Emitter[JoinKey, TaggedValue]) error {
+			key, value, ok := j.Extract(rec)
+			if !ok {
+				return nil
+			}
+			return out.Emit(JoinKey{Key: key, Tag: rec.Source},
+				TaggedValue{Tag: rec.Source, Value: value})
+		},
+		Reduce: func(key JoinKey, values *Values[JoinKey, TaggedValue],
+			out Emitter[string, string]) error {
+			return join(key.Key, values, out)
+		},
+		Partition: func(key JoinKey, parts int) int {
+			return HashPartition(key.Key, parts)
+		},
+		Compare: compareJoinKeys, Group: func(a, b JoinKey) int {
+			return strings.Compare(a.Key, b.Key)
+		},
+		KeySerializer:   JSONSerializer[JoinKey]{},
+		ValueSerializer: JSONSerializer[TaggedValue]{}}
+}
+
+// compareJoinKeys compares two join keys by key and tag
+func compareJoinKeys(a, b JoinKey) int {
+	if c := strings.Compare(a.Key, b.Key); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Tag, b.Tag)
+}
+
+// InnerJoin returns the inner join of the specified number of tagged inputs.
+// The values of a join key read from all the inputs but the one with the
+// largest tag are buffered, and the key is emitted with each combination of
+// one value per input, separated by tabs in tag order. Keys missing from any
+// of the inputs produce no output, while keys read from more inputs than
+// expected are an error
+func InnerJoin(inputs int) func(key string,
+	values *Values[JoinKey, TaggedValue], out Emitter[string, string]) error {
+	return func(key string, values *Values[JoinKey, TaggedValue],
+		out Emitter[string, string]) error {
+		var tag string
+		var buffered [][]string
+		for values.HasNext() {
+			v, err := values.Next()
+			if err != nil {
+				return err
+			}
+			if len(buffered) == 0 || v.Tag != tag {
+				if len(buffered) == inputs {
+					return fmt.Errorf("innerjoin: key %s read from more "+
+						"than %d inputs", key, inputs)
+				}
+				tag = v.Tag
+				buffered = append(buffered, nil)
+			}
+			if len(buffered) < inputs {
+				last := len(buffered) - 1
+				buffered[last] = append(buffered[last], v.Value)
+				continue
+			}
+
+			err = joinValues(buffered[:inputs-1], "",
+				func(prefix string) error {
+					return out.Emit(key, prefix+v.Value)
+				})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// joinValues calls emit with each combination of one value per group, each
+// value followed by a tab
+func joinValues(groups [][]string, prefix string,
+	emit func(prefix string) error) error {
+	if len(groups) == 0 {
+		return emit(prefix)
+	}
+	for _, value := range groups[0] {
+		if err := joinValues(groups[1:], prefix+value+"\t", emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MapSideJoin describes a hash join of the job inputs with a small dataset,
+// performed by the Mapper tasks of a map-only job. The small dataset is the
+// side file of the job named File, holding tab separated key / value pairs,
+// and is loaded in memory by each task. Extract returns the join key and the
+// value of an input record, and false if the record does not take part in
+// the join. Join is called for each value of the small dataset with the same
+// key as the record, and defaults to emitting the key with the record value
+// and the dataset value separated by a tab. Records without matching values
+// are dropped, unless LeftOuter is true, in which case Join is called with
+// an empty dataset value
+type MapSideJoin struct {
+	File      string
+	Extract   func(rec formats.Record) (string, string, bool)
+	Join      func(key, value, side string, out Emitter[string, string]) error
+	LeftOuter bool
+}
+
+// Job returns a job performing the join
+func (j MapSideJoin) Job() Job[string, string, string, string] {
+	return Job[string, string, string, string]{
+		Map: func(formats.Record, Emitter[string, string]) error {
+			return fmt.Errorf("map: dataset %s not loaded", j.File)
+		},
+		KeySerializer: StringSerializer{}, ValueSerializer: StringSerializer{},
+		Setup: j.setup}
+}
+
+// setup loads the small dataset from the cache and returns a job joining
+// the input records with it
+func (j MapSideJoin) setup(task Task) (Job[string, string, string, string],
+	error) {
+	table, err := loadJoinTable(task, j.File)
+	if err != nil {
+		return Job[string, string, string, string]{}, err
+	}
+
+	join := j.Join
+	if join == nil {
+		join = func(key, value, side string,
+			out Emitter[string, string]) error {
+			return out.Emit(key, value+"\t"+side)
+		}
+	}
+
+	mapJoin := func(rec formats.Record, out Emitter[string, string]) error {
+		key, value, ok := j.Extract(rec)
+		if !ok {
+			return nil
+		}
+
+		sides, ok := table[key]
+		if !ok && j.LeftOuter {
+			sides = []string{""}
+		}
+		for _, side := range sides {
+			if err := join(key, value, side, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return Job[string, string, string, string]{Map: mapJoin}, nil
+}
+
+// loadJoinTable reads the tab separated key / value pairs of a side file and
+// returns the values of each key. Lines without a tab are keys with an empty
+// value
+func loadJoinTable(cache Cache, name string) (map[string][]string, error) {
+	f, err := cache.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	table := make(map[string][]string)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		key, value, _ := strings.Cut(sc.Text(), "\t")
+		table[key] = append(table[key], value)
+	}
+	return table, sc.Err()
+}
+
+// extractKeyValue uses the key and the value of a record, such as a record
+// of the tsv input format, as join key and value
+func extractKeyValue(rec formats.Record) (string, string, bool) {
+	return rec.Key, rec.Value, true
+}
+
+// The join and map_join programs join datasets of tab separated key / value
+// pairs, read with the tsv input format. The join program joins the tagged
+// inputs of a job on the Reducer tasks, while the map_join program joins the
+// job inputs with the join.tsv side file on the Mapper tasks of a map-only
+// job
+func init() {
+	RegisterJob("join", ReduceSideJoin{Extract: extractKeyValue}.Job())
+	RegisterJob("map_join", MapSideJoin{File: "join.tsv",
+		Extract: extractKeyValue}.Job())
+}
@@ -0,0 +1,155 @@
+package roles
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+// dirTask is a task whose side files are stored in a directory
+type dirTask string
+
+func (c dirTask) Path(name string) (string, error) {
+	return filepath.Join(string(c), name), nil
+}
+
+func (c dirTask) Open(name string) (*os.File, error) {
+	return os.Open(filepath.Join(string(c), name))
+}
+
+func (c dirTask) Output(name string) (Emitter[string, string], error) {
+	return nil, fmt.Errorf("output: no named outputs")
+}
+
+// extractFirstField uses the first space separated field of a record as join
+// key and the rest of the record as value
+func extractFirstField(rec formats.Record) (string, string, bool) {
+	return strings.Cut(rec.Value, " ")
+}
+
+// runReduceSideJoin maps each input separately, as different Map tasks
+// would, and reduces the merged map outputs
+func runReduceSideJoin(t *testing.T, join ReduceSideJoin,
+	inputs map[string][]string) ([]string, error) {
+	prog := join.Job().program()
+	readers := []io.Reader{}
+	for tag, values := range inputs {
+		pairs := [][2]string{}
+		out := EmitterFunc[string, string](func(k, v string) error {
+			pairs = append(pairs, [2]string{k, v})
+			return nil
+		})
+		for _, value := range values {
+			rec := formats.Record{Value: value, Source: tag}
+			if err := prog.Map(rec, out); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		sort.SliceStable(pairs, func(i, j int) bool {
+			return prog.Order.Compare(pairs[i][0], pairs[j][0]) < 0
+		})
+
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		for _, pair := range pairs {
+			if err := utils.WriteKeyValue(w, pair[0], pair[1]); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		w.Flush()
+		readers = append(readers, &buf)
+	}
+
+	kvIt, err := utils.MakeKeyValueIterator(prog.Order, readers...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res := []string{}
+	out := EmitterFunc[string, string](func(k, v string) error {
+		res = append(res, k+"="+v)
+		return nil
+	})
+	for kvIt.HasNext() {
+		key, vIt := kvIt.Next()
+		if err := prog.Reduce(key, vIt, out); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func TestReduceSideJoin(t *testing.T) {
+	join := ReduceSideJoin{Extract: extractFirstField, Inputs: 2}
+	res, err := runReduceSideJoin(t, join, map[string][]string{
+		"orders": {"u1 book", "u2 pen", "u1 lamp", "u3 desk"},
+		"users":  {"u2 bob", "u1 alice", "u4 dan"},
+	})
+	expected := []string{"u1=book\talice", "u1=lamp\talice", "u2=pen\tbob"}
+	if err != nil || !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected: %q, actual: %q, error: %v", expected, res, err)
+	}
+
+	// Keys are joined across all the inputs, and only if read from all of
+	// them
+	join.Inputs = 3
+	res, err = runReduceSideJoin(t, join, map[string][]string{
+		"cities": {"u1 rome", "u2 oslo", "u1 nice"},
+		"orders": {"u1 book", "u2 pen", "u1 lamp", "u3 desk"},
+		"users":  {"u1 alice", "u3 carl"},
+	})
+	expected = []string{"u1=rome\tbook\talice", "u1=rome\tlamp\talice",
+		"u1=nice\tbook\talice", "u1=nice\tlamp\talice"}
+	if err != nil || !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected: %q, actual: %q, error: %v", expected, res, err)
+	}
+
+	// Keys read from more inputs than expected are an error
+	join.Inputs = 2
+	res, err = runReduceSideJoin(t, join, map[string][]string{
+		"a": {"k 1"}, "b": {"k 2"}, "c": {"k 3"}})
+	if err == nil {
+		t.Errorf("expected error for unexpected input, got: %q", res)
+	}
+}
+
+func TestMapSideJoin(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "users.tsv"),
+		[]byte("u1\talice\nu2\tbob\n"), 0644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	join := MapSideJoin{File: "users.tsv", Extract: extractFirstField,
+		LeftOuter: true}
+	prog, err := join.Job().program().Setup(dirTask(dir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res := []string{}
+	out := EmitterFunc[string, string](func(k, v string) error {
+		res = append(res, k+"="+v)
+		return nil
+	})
+	for _, value := range []string{"u1 book", "u3 desk", "u2 pen"} {
+		if err := prog.Map(formats.Record{Value: value}, out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := []string{"u1=book\talice", "u3=desk\t", "u2=pen\tbob"}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected: %q, actual: %q", expected, res)
+	}
+}
@@ -3,6 +3,7 @@ package roles
 import (
 	"strings"
 
+	"github.com/giulioborghesi/mapreduce/formats"
 	"github.com/giulioborghesi/mapreduce/utils"
 )
 
@@ -10,13 +11,17 @@ import (
 type Mapper struct{}
 
 // Map implements the Map function used by MapReduce to map values to
-// a dictionary of key-values pairs
-func (m *Mapper) Map(val string, dict map[string][]string) {
-	vals := strings.Split(val, " ")
+// key-values pairs. Each word of the record value is emitted with a count of
+// one
+func (m *Mapper) Map(rec formats.Record, out Emitter[string, int64]) error {
+	vals := strings.Split(rec.Value, " ")
 	for _, s := range vals {
 		ns := utils.NormalizeString(s)
 		if len(ns) > 0 {
-			dict[ns] = append(dict[ns], "1")
+			if err := out.Emit(ns, 1); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
@@ -1,5 +1,7 @@
 package roles
 
+import "hash/fnv"
+
 // Partition computes the partition a key should be assigned to
 func Partition(key string, parts int) int {
 	var c int = int(key[0]) - int('a')
@@ -11,3 +13,10 @@ func Partition(key string, parts int) int {
 	}
 	return c % parts
 }
+
+// HashPartition assigns a key to a partition based on its hash
+func HashPartition(key string, parts int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(parts))
+}
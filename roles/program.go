@@ -0,0 +1,82 @@
+package roles
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+const (
+	// DefaultProgram is the program executed by jobs that do not specify one
+	DefaultProgram = "wordcount"
+)
+
+// Program describes the user code executed by the tasks of a job, working on
+// encoded keys and values. Map emits the key / value pairs generated from an
+// input record, while Reduce emits the output key / value pairs, formatted as
+// text, generated from the values of a group of keys. Partition assigns
+// keys to Reducer tasks, and Order defines how keys are sorted and grouped.
+// Keys in the same group must be assigned to the same partition. Format
+// formats the output of map-only jobs as text, and keys and values are
+// written as they are if it is nil. Setup, if not nil, is called by each task
+// before processing any record, and returns the program executed by the
+// task; programs use it to load the side files of the job and to write to
+// its named outputs. Programs working on native types are usually created with
+// RegisterJob
+type Program struct {
+	Map    func(rec formats.Record, out Emitter[string, string]) error
+	Reduce func(key string, it *utils.ValueIterator,
+		out Emitter[string, string]) error
+	Partition func(key string, parts int) int
+	Order     utils.KeyOrder
+	Format    func(key, value string) (string, string, error)
+	Setup     func(task Task) (Program, error)
+}
+
+var (
+	programs   = make(map[string]Program)
+	programsMu sync.Mutex
+)
+
+// Register makes a program available to jobs under the specified name. This
+// function will panic if a program with the same name is already registered
+// or if the program has no Map function
+func Register(name string, p Program) {
+	programsMu.Lock()
+	defer programsMu.Unlock()
+
+	if _, ok := programs[name]; ok {
+		panic(fmt.Sprintf("register: program %s already registered", name))
+	}
+	if p.Map == nil {
+		panic(fmt.Sprintf("register: program %s has no map function", name))
+	}
+	if p.Partition == nil {
+		p.Partition = Partition
+	}
+	programs[name] = p
+}
+
+// Lookup returns the program registered under the specified name. The
+// default program is returned if name is empty
+func Lookup(name string) (Program, error) {
+	programsMu.Lock()
+	defer programsMu.Unlock()
+
+	if name == "" {
+		name = DefaultProgram
+	}
+	p, ok := programs[name]
+	if !ok {
+		return p, fmt.Errorf("lookup: unknown program: %s", name)
+	}
+	return p, nil
+}
+
+func init() {
+	RegisterJob(DefaultProgram, Job[string, int64, string, int64]{
+		Map: (&Mapper{}).Map, Reduce: (&Reducer{}).Reduce,
+		Partition: Partition})
+}
@@ -1,11 +1,5 @@
 package roles
 
-import (
-	"strconv"
-
-	"github.com/giulioborghesi/mapreduce/utils"
-)
-
 // Reducer is a struct that implements the MapReduce reduce function
 type Reducer struct{}
 
@@ -13,8 +7,9 @@ type Reducer struct{}
 // values that maps to the same key. The Reduce function implemented here
 // is used alongside the Map function to count the occurrence of words in
 // a text file
-func (m *Reducer) Reduce(key string, it *utils.ValueIterator) (string, error) {
-	res := 0
+func (m *Reducer) Reduce(key string, it *Values[string, int64],
+	out Emitter[string, int64]) error {
+	var res int64
 	for {
 		if !it.HasNext() {
 			break
@@ -22,16 +17,10 @@ func (m *Reducer) Reduce(key string, it *utils.ValueIterator) (string, error) {
 
 		val, err := it.Next()
 		if err != nil {
-			return "", err
-		}
-
-		ival, err := strconv.Atoi(val)
-		if err != nil {
-			return "", err
+			return err
 		}
-		res += ival
+		res += val
 	}
 
-	return strconv.Itoa(res), nil
-
+	return out.Emit(key, res)
 }
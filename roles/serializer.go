@@ -0,0 +1,175 @@
+package roles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Serializer encodes values of type T to bytes and decodes them back. The
+// framework uses serializers to store typed keys and values in intermediate
+// files. Encodings must be deterministic, since keys are grouped by comparing
+// their encodings, and keys are sorted by their encodings unless the job
+// provides a comparator
+type Serializer[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// StringSerializer stores strings as they are
+type StringSerializer struct{}
+
+// Encode returns the bytes of a string
+func (StringSerializer) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+// Decode returns a string holding the data
+func (StringSerializer) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// BytesSerializer stores byte slices as they are
+type BytesSerializer struct{}
+
+// Encode returns the byte slice
+func (BytesSerializer) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+// Decode returns the data
+func (BytesSerializer) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// Integer is the set of integer types supported by IntSerializer
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// IntSerializer stores integers as 8 bytes big-endian numbers. The sign bit
+// of signed integers is flipped, so that the order of the encodings matches
+// the order of the integers
+type IntSerializer[T Integer] struct{}
+
+// signBit returns the bit to flip in the encoding of integers of type T
+func signBit[T Integer]() uint64 {
+	var zero T
+	if zero-1 < zero {
+		return 1 << 63
+	}
+	return 0
+}
+
+// Encode returns the encoding of an integer
+func (IntSerializer[T]) Encode(v T) ([]byte, error) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(v)^signBit[T]())
+	return data, nil
+}
+
+// Decode returns the integer with the specified encoding
+func (IntSerializer[T]) Decode(data []byte) (T, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("decode: invalid integer encoding length: %d",
+			len(data))
+	}
+	return T(binary.BigEndian.Uint64(data) ^ signBit[T]()), nil
+}
+
+// JSONSerializer stores values as JSON documents
+type JSONSerializer[T any] struct{}
+
+// Encode returns the JSON encoding of a value
+func (JSONSerializer[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode returns the value with the specified JSON encoding
+func (JSONSerializer[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobSerializer stores values using the gob encoding. Since the gob encoding
+// of a value depends on the types encoded before by the process, it should
+// not be used for keys
+type GobSerializer[T any] struct{}
+
+// Encode returns the gob encoding of a value
+func (GobSerializer[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode returns the value with the specified gob encoding
+func (GobSerializer[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// defaultSerializer returns the built-in serializer for values of type T:
+// strings, byte slices and integers are stored natively, while any other
+// value is stored using the gob encoding
+func defaultSerializer[T any]() Serializer[T] {
+	if s, ok := nativeSerializer[T](); ok {
+		return s
+	}
+	return GobSerializer[T]{}
+}
+
+// defaultKeySerializer returns the built-in serializer for keys of type T.
+// Keys are partitioned and grouped by their encoding, which must therefore
+// not depend on the process encoding them: keys that cannot be stored
+// natively are stored as JSON documents rather than using the gob encoding,
+// whose output depends on the types encoded before
+func defaultKeySerializer[T any]() Serializer[T] {
+	if s, ok := nativeSerializer[T](); ok {
+		return s
+	}
+	return JSONSerializer[T]{}
+}
+
+// nativeSerializer returns the serializer storing values of type T natively,
+// if any
+func nativeSerializer[T any]() (Serializer[T], bool) {
+	var s any
+	var zero T
+	switch any(zero).(type) {
+	case string:
+		s = StringSerializer{}
+	case []byte:
+		s = BytesSerializer{}
+	case int:
+		s = IntSerializer[int]{}
+	case int8:
+		s = IntSerializer[int8]{}
+	case int16:
+		s = IntSerializer[int16]{}
+	case int32:
+		s = IntSerializer[int32]{}
+	case int64:
+		s = IntSerializer[int64]{}
+	case uint:
+		s = IntSerializer[uint]{}
+	case uint8:
+		s = IntSerializer[uint8]{}
+	case uint16:
+		s = IntSerializer[uint16]{}
+	case uint32:
+		s = IntSerializer[uint32]{}
+	case uint64:
+		s = IntSerializer[uint64]{}
+	default:
+		return nil, false
+	}
+	return s.(Serializer[T]), true
+}
@@ -0,0 +1,58 @@
+package roles
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIntSerializerOrder(t *testing.T) {
+	s := IntSerializer[int64]{}
+	vals := []int64{-1 << 40, -5, -1, 0, 1, 7, 1 << 40}
+	var prev []byte
+	for idx, val := range vals {
+		data, err := s.Encode(val)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if idx > 0 && bytes.Compare(prev, data) >= 0 {
+			t.Errorf("encoding of %d not greater than encoding of %d", val,
+				vals[idx-1])
+		}
+		prev = data
+
+		res, err := s.Decode(data)
+		if err != nil || res != val {
+			t.Errorf("expected: %d, actual: %d, error: %v", val, res, err)
+		}
+	}
+}
+
+func TestDefaultSerializer(t *testing.T) {
+	type pair struct {
+		Key   string
+		Value int
+	}
+
+	s := defaultSerializer[pair]()
+	val := pair{Key: "mapreduce", Value: 3}
+	data, err := s.Encode(val)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := s.Decode(data)
+	if err != nil || res != val {
+		t.Errorf("expected: %v, actual: %v, error: %v", val, res, err)
+	}
+
+	if _, ok := defaultSerializer[uint32]().(IntSerializer[uint32]); !ok {
+		t.Errorf("expected integer serializer for uint32")
+	}
+
+	// Keys that are not stored natively are stored as JSON documents, whose
+	// encoding does not depend on the process
+	data, err = defaultKeySerializer[pair]().Encode(val)
+	if expected := `{"Key":"mapreduce","Value":3}`; err != nil ||
+		string(data) != expected {
+		t.Errorf("expected: %s, actual: %s, error: %v", expected, data, err)
+	}
+}
@@ -0,0 +1,59 @@
+package roles
+
+import (
+	"bufio"
+	"strings"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+const (
+	// stopWordsFile is the side file listing the words ignored by the stop
+	// words program, one per line
+	stopWordsFile = "stopwords.txt"
+)
+
+// stopWords is a program that counts the occurrences of the words of a text,
+// ignoring the words listed in the stopwords.txt side file of the job
+func init() {
+	RegisterJob("wordcount_stopwords", Job[string, int64, string, int64]{
+		Map: (&Mapper{}).Map, Reduce: (&Reducer{}).Reduce,
+		Setup: setupStopWords})
+}
+
+// setupStopWords loads the stop words from the cache and returns a job whose
+// Map function skips them
+func setupStopWords(task Task) (Job[string, int64, string, int64], error) {
+	f, err := task.Open(stopWordsFile)
+	if err != nil {
+		return Job[string, int64, string, int64]{}, err
+	}
+	defer f.Close()
+
+	skip := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if word := utils.NormalizeString(sc.Text()); word != "" {
+			skip[word] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return Job[string, int64, string, int64]{}, err
+	}
+
+	mapWords := func(rec formats.Record, out Emitter[string, int64]) error {
+		for _, s := range strings.Split(rec.Value, " ") {
+			ns := utils.NormalizeString(s)
+			if len(ns) == 0 || skip[ns] {
+				continue
+			}
+			if err := out.Emit(ns, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return Job[string, int64, string, int64]{Map: mapWords,
+		Reduce: (&Reducer{}).Reduce}, nil
+}
@@ -0,0 +1,79 @@
+package roles
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+)
+
+// eventKey is the intermediate key of the user events program
+type eventKey struct {
+	User string
+	Time int64
+}
+
+// userEvents is a program that collects the events of each user in
+// chronological order. Input records have the format "user timestamp event",
+// and the program outputs, for each user, the comma separated list of the
+// user events sorted by timestamp. Intermediate keys are sorted by user and
+// timestamp and grouped by user
+func init() {
+	RegisterJob("user_events", Job[eventKey, string, string, string]{
+		Map: mapUserEvent, Reduce: reduceUserEvents,
+		Partition: partitionByUser, Compare: compareUserEvents,
+		Group: compareUsers, KeySerializer: JSONSerializer[eventKey]{}})
+}
+
+// mapUserEvent maps an event record to a key made of user and timestamp.
+// Malformed records are ignored
+func mapUserEvent(rec formats.Record, out Emitter[eventKey, string]) error {
+	fields := strings.Fields(rec.Value)
+	if len(fields) != 3 {
+		return nil
+	}
+	ts, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return out.Emit(eventKey{User: fields[0], Time: ts}, fields[2])
+}
+
+// reduceUserEvents joins the events of a user, which are received sorted by
+// timestamp
+func reduceUserEvents(key eventKey, it *Values[eventKey, string],
+	out Emitter[string, string]) error {
+	events := make([]string, 0)
+	for it.HasNext() {
+		event, err := it.Next()
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+	}
+	return out.Emit(key.User, strings.Join(events, ","))
+}
+
+// partitionByUser assigns the events of a user to the same partition
+func partitionByUser(key eventKey, parts int) int {
+	return HashPartition(key.User, parts)
+}
+
+// compareUserEvents sorts intermediate keys by user and then by timestamp
+func compareUserEvents(a, b eventKey) int {
+	if c := compareUsers(a, b); c != 0 {
+		return c
+	}
+	switch {
+	case a.Time < b.Time:
+		return -1
+	case a.Time > b.Time:
+		return 1
+	}
+	return 0
+}
+
+// compareUsers groups intermediate keys by user
+func compareUsers(a, b eventKey) int {
+	return strings.Compare(a.User, b.User)
+}
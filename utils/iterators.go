@@ -8,7 +8,8 @@ import (
 )
 
 // inputIterator represents an iterator over the key-value pairs stored in a
-// input stream that satisfies the io.Reader interface
+// input stream that satisfies the io.Reader interface. Pairs are stored in
+// the format written by WriteKeyValue
 type inputIterator struct {
 	reader     *bufio.Reader
 	key, value string
@@ -35,7 +36,7 @@ func (it *inputIterator) next() error {
 		return nil
 	}
 
-	s, err := it.reader.ReadString('\n')
+	key, value, err := ReadKeyValue(it.reader)
 	if err == io.EOF {
 		it.end = true
 		return nil
@@ -45,57 +46,112 @@ func (it *inputIterator) next() error {
 		return err
 	}
 
-	vals := strings.Split(s, " ")
-	it.key = vals[0]
-	it.value = strings.TrimRight(vals[1], "\n")
+	it.key, it.value = key, value
 	return nil
 }
 
+// KeyOrder describes how keys are sorted and grouped. Compare defines the
+// order in which keys are sorted, while Group decides which keys have their
+// values passed to the same Reduce call. Keys that are equal according to
+// Group must be adjacent in the order defined by Compare. Both functions
+// return a negative number, zero or a positive number when the first key is
+// smaller than, equal to or greater than the second key. Keys are sorted in
+// lexicographic order if Compare is nil, and grouped using Compare if Group
+// is nil
+type KeyOrder struct {
+	Compare func(a, b string) int
+	Group   func(a, b string) int
+}
+
+// compare compares two keys using the sort comparator
+func (o KeyOrder) compare(a, b string) int {
+	if o.Compare == nil {
+		return strings.Compare(a, b)
+	}
+	return o.Compare(a, b)
+}
+
+// group compares two keys using the grouping comparator
+func (o KeyOrder) group(a, b string) int {
+	if o.Group == nil {
+		return o.compare(a, b)
+	}
+	return o.Group(a, b)
+}
+
+// Less returns true if the first key sorts before the second key
+func (o KeyOrder) Less(a, b string) bool {
+	return o.compare(a, b) < 0
+}
+
 // ValueIterator implements an iterator over the values associated with a
-// single key
+// group of keys. Values are returned in key order, and values with equal keys
+// in the order of their input sources
 type ValueIterator struct {
-	Key string
-	its []*inputIterator
+	Key   string
+	key   string
+	its   []*inputIterator
+	order KeyOrder
 }
 
 // HasNext returns true if there exists a non-processed value for the
-// current key, and false otherwise
+// current group of keys, and false otherwise. It also removes the inputs that
+// have no more values for the group, preserving the order of the others
 func (it *ValueIterator) HasNext() bool {
+	n := 0
+	for _, ptrIt := range it.its {
+		if !ptrIt.end && it.order.group(ptrIt.key, it.Key) == 0 {
+			it.its[n] = ptrIt
+			n++
+		}
+	}
+	it.its = it.its[:n]
 	return len(it.its) > 0
 }
 
-// Next returns the next unprocessed value for the current key. The method
-// assumes that such value exists, and will panic otherwise
+// Next returns the next unprocessed value for the current group of keys. The
+// method assumes that such value exists, and will panic otherwise
 func (it *ValueIterator) Next() (string, error) {
 	if it.HasNext() == false {
 		panic(fmt.Sprintf("ValueIterator: no additional value exists for "+
 			"key %s", it.Key))
 	}
 
-	// Fetch next value
-	value := it.its[0].value
-	if err := it.its[0].next(); err != nil {
-		return "", err
+	// Fetch next value from the input with the smallest key, or from the
+	// first of these inputs if several have the smallest key
+	min := it.its[0]
+	for _, ptrIt := range it.its[1:] {
+		if it.order.Less(ptrIt.key, min.key) {
+			min = ptrIt
+		}
 	}
 
-	// Remove iterator if it has exhausted the values for the current key
-	if it.its[0].end || it.its[0].key != it.Key {
-		len := len(it.its)
-		it.its[0], it.its[len-1] = it.its[len-1], it.its[0]
-		it.its = it.its[:len-1]
+	it.key = min.key
+	value := min.value
+	if err := min.next(); err != nil {
+		return "", err
 	}
 	return value, nil
 }
 
+// ValueKey returns the key of the last value returned by Next. It differs
+// from the key of the group when a grouping comparator is used
+func (it *ValueIterator) ValueKey() string {
+	return it.key
+}
+
 // KeyValueIterator implements an iterator over the key-values pairs extracted
 // from several input sources that satisfies the io.Reader interface
 type KeyValueIterator struct {
-	its []inputIterator
+	its   []inputIterator
+	order KeyOrder
 }
 
 // MakeKeyValueIterator creates and initializes a pointer to a new
-// KeyValueIterator object
-func MakeKeyValueIterator(rs ...io.Reader) (*KeyValueIterator, error) {
+// KeyValueIterator object. The input sources must be sorted according to the
+// specified key order
+func MakeKeyValueIterator(order KeyOrder,
+	rs ...io.Reader) (*KeyValueIterator, error) {
 	its := []inputIterator{}
 	for _, r := range rs {
 		it, err := makeInputIterator(r)
@@ -105,42 +161,73 @@ func MakeKeyValueIterator(rs ...io.Reader) (*KeyValueIterator, error) {
 		its = append(its, *it)
 	}
 
-	return &KeyValueIterator{its: its}, nil
+	return &KeyValueIterator{its: its, order: order}, nil
 }
 
-// Next returns the key and corresponding values iterator for the next
-// unprocessed key-values pair. The method assumes that data has not been fully
-// consumed yet, and will panic should this condition not be satisfied
+// Next returns the smallest key of the next unprocessed group of keys and the
+// iterator over the values of the group. Groups are returned in increasing
+// key order, provided that each input source is sorted. The method assumes
+// that data has not been fully consumed yet, and will panic should this
+// condition not be satisfied
 func (kvIt *KeyValueIterator) Next() (string, *ValueIterator) {
 	if kvIt.HasNext() == false {
 		panic(fmt.Sprintf("KeyValueIterator: no additional key-values exists"))
 	}
 
 	key := kvIt.its[0].key
-	its := []*inputIterator{}
 	for i := range kvIt.its {
-		ptrIt := &kvIt.its[i]
-		if key == ptrIt.key {
-			its = append(its, ptrIt)
-		} else if key < ptrIt.key {
-			key = ptrIt.key
-			its = []*inputIterator{ptrIt}
+		if kvIt.order.Less(kvIt.its[i].key, key) {
+			key = kvIt.its[i].key
 		}
 	}
 
-	return key, &ValueIterator{Key: key, its: its}
+	its := []*inputIterator{}
+	for i := range kvIt.its {
+		if kvIt.order.group(kvIt.its[i].key, key) == 0 {
+			its = append(its, &kvIt.its[i])
+		}
+	}
+	return key, &ValueIterator{Key: key, its: its, order: kvIt.order}
 }
 
 // HasNext returns true if the iterator still has unprocessed key-values pairs,
-// and false otherwise. It also removes the fully processed iterators
+// and false otherwise. It also removes the fully processed iterators,
+// preserving the order of the others
 func (kvIt *KeyValueIterator) HasNext() bool {
-	n := len(kvIt.its)
-	for i := len(kvIt.its) - 1; i >= 0; i-- {
-		if kvIt.its[i].end == true {
-			kvIt.its[i], kvIt.its[n-1] = kvIt.its[n-1], kvIt.its[i]
-			n--
+	n := 0
+	for i := range kvIt.its {
+		if !kvIt.its[i].end {
+			kvIt.its[n] = kvIt.its[i]
+			n++
 		}
 	}
 	kvIt.its = kvIt.its[:n]
 	return len(kvIt.its) > 0
 }
+
+// MergeKeyValues merges several input sources of key-value pairs sorted
+// according to the specified key order into a single sorted stream that is
+// written to w
+func MergeKeyValues(w io.Writer, order KeyOrder, rs ...io.Reader) error {
+	kvIt, err := MakeKeyValueIterator(order, rs...)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(w)
+	for kvIt.HasNext() {
+		_, vIt := kvIt.Next()
+		for vIt.HasNext() {
+			value, err := vIt.Next()
+			if err != nil {
+				return err
+			}
+
+			err = WriteKeyValue(writer, vIt.ValueKey(), value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return writer.Flush()
+}
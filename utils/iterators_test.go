@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// encodePairs converts lines of space separated key-value pairs to the
+// binary format of the intermediate files
+func encodePairs(lines string) io.Reader {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for _, line := range strings.Split(strings.TrimSpace(lines), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			WriteKeyValue(w, fields[0], fields[1])
+		}
+	}
+	w.Flush()
+	return &buf
+}
+
+// decodePairs converts key-value pairs in the binary format of the
+// intermediate files to lines of space separated key-value pairs
+func decodePairs(r io.Reader) (string, error) {
+	var res strings.Builder
+	reader := bufio.NewReader(r)
+	for {
+		key, value, err := ReadKeyValue(reader)
+		if err == io.EOF {
+			return res.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		res.WriteString(key + " " + value + "\n")
+	}
+}
+
+func TestKeyValueIterator(t *testing.T) {
+	// Create iterator over two sorted sources sharing some keys
+	kvIt, err := MakeKeyValueIterator(KeyOrder{},
+		encodePairs("a 1\nb 2\nd 3\n"),
+		encodePairs("b 4\nc 5\n"))
+	if err != nil {
+		t.Fatalf("Iterator creation failed: %v", err)
+	}
+
+	// Keys should be returned in increasing order, each exactly once
+	wantKeys := []string{"a", "b", "c", "d"}
+	wantCnts := []int{1, 2, 1, 1}
+	for i := range wantKeys {
+		if !kvIt.HasNext() {
+			t.Fatalf("Iterator exhausted early, got: %d keys, want: %d", i,
+				len(wantKeys))
+		}
+
+		key, vIt := kvIt.Next()
+		if key != wantKeys[i] {
+			t.Errorf("Key incorrect, got: %s, want: %s", key, wantKeys[i])
+		}
+
+		cnt := 0
+		for vIt.HasNext() {
+			if _, err := vIt.Next(); err != nil {
+				t.Fatalf("Value iteration failed: %v", err)
+			}
+			cnt++
+		}
+		if cnt != wantCnts[i] {
+			t.Errorf("Values count incorrect for key %s, got: %d, want: %d",
+				key, cnt, wantCnts[i])
+		}
+	}
+
+	if kvIt.HasNext() {
+		t.Errorf("Iterator should be exhausted")
+	}
+}
+
+func TestMergeKeyValues(t *testing.T) {
+	rs := []io.Reader{encodePairs("b 1\nc 1\n"),
+		encodePairs("a 1\nc 2\n"), encodePairs("")}
+
+	var buf bytes.Buffer
+	if err := MergeKeyValues(&buf, KeyOrder{}, rs...); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	got, err := decodePairs(&buf)
+	if err != nil {
+		t.Fatalf("Decoding failed: %v", err)
+	}
+	want := "a 1\nb 1\nc 1\nc 2\n"
+	if got != want {
+		t.Errorf("Merged output incorrect, got: %q, want: %q", got, want)
+	}
+
+	// Values with equal keys keep the order of their input sources, even
+	// after some of the sources have been fully processed
+	rs = []io.Reader{encodePairs("a 0\n"), encodePairs("b 1\nc 1\n"),
+		encodePairs("b 2\n"), encodePairs("b 3\nc 3\n")}
+	buf.Reset()
+	if err := MergeKeyValues(&buf, KeyOrder{}, rs...); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	got, _ = decodePairs(&buf)
+	want = "a 0\nb 1\nb 2\nb 3\nc 1\nc 3\n"
+	if got != want {
+		t.Errorf("Merged output incorrect, got: %q, want: %q", got, want)
+	}
+}
+
+func TestKeyValueIteratorGrouping(t *testing.T) {
+	// Sort composite keys by user and then by decreasing time, and group
+	// them by user
+	user := func(key string) string { return strings.Split(key, ":")[0] }
+	order := KeyOrder{
+		Compare: func(a, b string) int {
+			if c := strings.Compare(user(a), user(b)); c != 0 {
+				return c
+			}
+			return strings.Compare(b, a)
+		},
+		Group: func(a, b string) int {
+			return strings.Compare(user(a), user(b))
+		},
+	}
+
+	kvIt, err := MakeKeyValueIterator(order,
+		encodePairs("a:2 x\na:1 y\nb:1 z\n"),
+		encodePairs("a:3 w\nb:2 v\n"))
+	if err != nil {
+		t.Fatalf("Iterator creation failed: %v", err)
+	}
+
+	// Values of a group should be returned in key order
+	want := []string{"a:3 w,a:2 x,a:1 y", "b:2 v,b:1 z"}
+	for i := range want {
+		_, vIt := kvIt.Next()
+		got := []string{}
+		for vIt.HasNext() {
+			value, err := vIt.Next()
+			if err != nil {
+				t.Fatalf("Value iteration failed: %v", err)
+			}
+			got = append(got, vIt.ValueKey()+" "+value)
+		}
+		if strings.Join(got, ",") != want[i] {
+			t.Errorf("Group incorrect, got: %v, want: %v", got, want[i])
+		}
+	}
+
+	if kvIt.HasNext() {
+		t.Errorf("Iterator should be exhausted")
+	}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// WriteKeyValue writes a key-value pair to w using the binary format of the
+// intermediate files: the lengths of the key and of the value, encoded as
+// unsigned varints, followed by the key and the value. Keys and values can
+// thus contain arbitrary bytes
+func WriteKeyValue(w *bufio.Writer, key, value string) error {
+	var buf [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(key)))
+	n += binary.PutUvarint(buf[n:], uint64(len(value)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	if _, err := w.WriteString(key); err != nil {
+		return err
+	}
+	_, err := w.WriteString(value)
+	return err
+}
+
+// ReadKeyValue reads a key-value pair written by WriteKeyValue from r. It
+// returns io.EOF if r contains no more pairs, and io.ErrUnexpectedEOF if the
+// last pair is truncated
+func ReadKeyValue(r *bufio.Reader) (string, string, error) {
+	keyLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", "", err
+	}
+	valueLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", "", noEOF(err)
+	}
+
+	buf := make([]byte, keyLen+valueLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", "", noEOF(err)
+	}
+	return string(buf[:keyLen]), string(buf[keyLen:]), nil
+}
+
+// noEOF converts io.EOF errors to io.ErrUnexpectedEOF errors
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
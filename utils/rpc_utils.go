@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
 	"net"
@@ -12,19 +13,26 @@ import (
 
 const (
 	connected = "200 Connected to Go RPC"
+	// dialTimeoutInMs is the maximum time allowed to establish a connection
+	dialTimeoutInMs = 500
 )
 
-// DialHTTP is a wrapper around rpc.DialHTTP. It is used to connect to an HTTP
-// RPC serverat the specified network address. Differently from rpc.DialHTTP,
-// a connection with a timeout is used
-func DialHTTP(network, address string, d time.Duration) (*rpc.Client, error) {
-	var err error
-	conn, err := net.DialTimeout(network, address, 500*time.Millisecond)
+// DialHTTPContext is a wrapper around rpc.DialHTTP. It is used to connect to
+// an HTTP RPC server at the specified network address. Differently from
+// rpc.DialHTTP, the connection must be established before the context is
+// done. The connection itself has no deadline, so that it can be reused for
+// several calls: use CallContext to enforce per-call deadlines
+func DialHTTPContext(ctx context.Context, network,
+	address string) (*rpc.Client, error) {
+	d := net.Dialer{Timeout: dialTimeoutInMs * time.Millisecond}
+	conn, err := d.DialContext(ctx, network, address)
 	if err != nil {
 		return nil, err
 	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
 	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
-	conn.SetDeadline(time.Now().Add(d))
 
 	// Require successful HTTP response
 	// before switching to RPC protocol.
@@ -32,6 +40,7 @@ func DialHTTP(network, address string, d time.Duration) (*rpc.Client, error) {
 		&http.Request{Method: "CONNECT"})
 
 	if err == nil && resp.Status == connected {
+		conn.SetDeadline(time.Time{})
 		return rpc.NewClient(conn), nil
 	}
 	if err == nil {
@@ -45,3 +54,17 @@ func DialHTTP(network, address string, d time.Duration) (*rpc.Client, error) {
 		Err:  err,
 	}
 }
+
+// CallContext invokes the named function on the RPC server and waits for it
+// to complete or for the context to be done, whichever happens first. In the
+// latter case, the context error is returned
+func CallContext(ctx context.Context, client *rpc.Client, method string,
+	args interface{}, reply interface{}) error {
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case res := <-call.Done:
+		return res.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
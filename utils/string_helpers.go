@@ -44,7 +44,7 @@ func GetPort(addr string) (string, error) {
 }
 
 // GetIntermediateFilePrefix returns the prefix of the intermediate file
-// generated by a Mapper task with a given index
-func GetIntermediateFilePrefix(file string, idx int) string {
-	return path.Base(file) + "." + strconv.Itoa(idx)
+// generated by a Mapper task with a given index of a given job
+func GetIntermediateFilePrefix(job string, idx int) string {
+	return path.Base(job) + "." + strconv.Itoa(idx)
 }
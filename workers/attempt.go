@@ -0,0 +1,121 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+)
+
+// Progress describes the progress of a task attempt. Map attempts report the
+// number of input bytes and records processed, while reduce attempts report
+// the fraction of map outputs fetched and the number of keys reduced
+type Progress struct {
+	BytesRead, BytesTotal int64
+	Records               int64
+	Shuffle               float64
+	Keys                  int64
+}
+
+// ProgressReport holds the progress of the task attempts running on a worker,
+// indexed by attempt ID
+type ProgressReport struct {
+	Attempts map[string]Progress
+}
+
+// attempt holds the state of a task attempt running on a worker. The progress
+// counters are updated by the attempt and read concurrently by the master.
+// Messages about the attempt are written to its log
+type attempt struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	log        *slog.Logger
+	logFile    *os.File
+	bytesRead  atomic.Int64
+	bytesTotal atomic.Int64
+	records    atomic.Int64
+	fetched    atomic.Int64
+	sources    atomic.Int64
+	keys       atomic.Int64
+}
+
+// progress returns a snapshot of the attempt progress
+func (a *attempt) progress() Progress {
+	p := Progress{BytesRead: a.bytesRead.Load(),
+		BytesTotal: a.bytesTotal.Load(), Records: a.records.Load(),
+		Keys: a.keys.Load()}
+	if sources := a.sources.Load(); sources > 0 {
+		p.Shuffle = float64(a.fetched.Load()) / float64(sources)
+	}
+	return p
+}
+
+// logger returns the logger of the attempt, or the default logger if the
+// attempt has no log
+func (a *attempt) logger() *slog.Logger {
+	if a.log == nil {
+		return slog.Default()
+	}
+	return a.log
+}
+
+// finish logs the outcome of the attempt. The last lines of the log of an
+// attempt that failed with an error are added to the reply
+func (a *attempt) finish(ctx *RequestContext, r *TaskReply) {
+	switch {
+	case r.Status == SUCCESS:
+		a.logger().Info("attempt succeeded")
+		return
+	case r.Error == "":
+		a.logger().Warn("attempt stopped")
+		return
+	}
+
+	a.logger().Error("attempt failed", "error", r.Error)
+	if a.logFile != nil {
+		r.LogTail, _ = logTail(a.logFile.Name(), logTailLines)
+	}
+}
+
+// startAttempt registers a new task attempt and creates its log, then
+// returns it. The attempt context is cancelled when the master cancels the
+// attempt. The returned function must be called once the attempt has
+// completed
+func (srvc *MapReduceService) startAttempt(ctx *RequestContext) (*attempt,
+	func()) {
+	a := new(attempt)
+	a.log, a.logFile = openTaskLog(srvc.cfg.Log, srvc.cfg.Address, ctx)
+
+	srvc.mu.Lock()
+	defer srvc.mu.Unlock()
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	if _, ok := srvc.cancelled[ctx.AttemptID]; ok {
+		delete(srvc.cancelled, ctx.AttemptID)
+		a.cancel()
+	}
+	srvc.attempts[ctx.AttemptID] = a
+
+	return a, func() {
+		srvc.mu.Lock()
+		delete(srvc.attempts, ctx.AttemptID)
+		srvc.mu.Unlock()
+		a.cancel()
+		if a.logFile != nil {
+			a.logFile.Close()
+		}
+	}
+}
+
+// TaskProgress is a RPC endpoint used by the master to retrieve the progress
+// of the task attempts running on a worker
+func (srvc *MapReduceService) TaskProgress(_ Void,
+	reply *ProgressReport) error {
+	srvc.mu.Lock()
+	defer srvc.mu.Unlock()
+
+	reply.Attempts = make(map[string]Progress, len(srvc.attempts))
+	for attemptID, a := range srvc.attempts {
+		reply.Attempts[attemptID] = a.progress()
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package workers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	cachePath = "/Users/giulioborghesi/tmp/cache/"
+	// releasedTTL is how long the worker remembers that a job has been
+	// released, so that late attempts of the job do not fetch its side files
+	// again
+	releasedTTL = 15 * time.Minute
+)
+
+// CacheFile describes a side file shipped with a job. Name is the base name
+// of the file and Checksum the hex encoded SHA-256 digest of its content
+type CacheFile struct {
+	Name, Checksum string
+	Size           int64
+}
+
+// jobCache holds the side files of a job stored on the worker, indexed by
+// name. Side files are fetched by the first task of the job executed by the
+// worker; tasks started while the files are being fetched wait for them.
+// Each path holds a reference to its file until the job is released
+type jobCache struct {
+	paths    map[string]string
+	ready    bool
+	released bool
+	sync.Mutex
+}
+
+// Path returns the local path of a side file
+func (c *jobCache) Path(name string) (string, error) {
+	path, ok := c.paths[name]
+	if !ok {
+		return "", fmt.Errorf("path: unknown side file: %s", name)
+	}
+	return path, nil
+}
+
+// Open opens a side file for reading
+func (c *jobCache) Open(name string) (*os.File, error) {
+	path, err := c.Path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// fetchCache downloads the side files of a job that are not stored on the
+// worker yet. Files are stored under the cache directory, named after their
+// checksum, and are only made visible once their checksum is verified. A
+// reference to each file is taken before checking whether the file exists,
+// so that the file cannot be removed by the release of another job in the
+// meantime; the references are dropped if the files cannot be fetched. The
+// caller must hold the cache lock
+func (srvc *MapReduceService) fetchCache(a *attempt, c *jobCache,
+	ctx *RequestContext) error {
+	if c.released {
+		return fmt.Errorf("fetchcache: job %s already released", ctx.JobID)
+	}
+	if c.ready {
+		return nil
+	}
+	if err := os.MkdirAll(cachePath, 0755); err != nil {
+		return err
+	}
+
+	for _, file := range ctx.Cache {
+		path := cachePath + file.Checksum
+		srvc.mu.Lock()
+		srvc.cacheRefs[path]++
+		srvc.mu.Unlock()
+		c.paths[file.Name] = path
+
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if err := fetchCacheFile(a, ctx.CacheURL, file, path); err != nil {
+			srvc.releaseCacheFiles(c.paths)
+			c.paths = make(map[string]string)
+			return err
+		}
+	}
+	c.ready = true
+	return nil
+}
+
+// releaseCacheFiles drops a reference to each of the side files at the
+// specified paths, and removes the files that are not referenced anymore
+func (srvc *MapReduceService) releaseCacheFiles(paths map[string]string) {
+	srvc.mu.Lock()
+	defer srvc.mu.Unlock()
+	for _, path := range paths {
+		srvc.cacheRefs[path]--
+		if srvc.cacheRefs[path] <= 0 {
+			delete(srvc.cacheRefs, path)
+			os.Remove(path)
+		}
+	}
+}
+
+// fetchCacheFile downloads a side file from the master and stores it at the
+// specified path
+func fetchCacheFile(a *attempt, baseURL string, file CacheFile,
+	path string) error {
+	req, err := http.NewRequestWithContext(a.ctx, http.MethodGet,
+		baseURL+"/"+file.Name, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetchcachefile: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetchcachefile: cannot fetch %s: %s", file.Name,
+			resp.Status)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		return fmt.Errorf("fetchcachefile: %v", err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != file.Checksum {
+		return fmt.Errorf("fetchcachefile: checksum mismatch for %s",
+			file.Name)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// jobCache returns the side files of the job a task attempt belongs to,
+// fetching them if needed. The side files used by the jobs are reference
+// counted, so that files shared by several jobs are only removed when the
+// last of these jobs finishes. Jobs without side files are not tracked, and
+// the attempts of jobs already released get no side files
+func (srvc *MapReduceService) jobCache(a *attempt,
+	ctx *RequestContext) (*jobCache, error) {
+	if len(ctx.Cache) == 0 {
+		return &jobCache{paths: make(map[string]string), ready: true}, nil
+	}
+
+	srvc.mu.Lock()
+	if _, ok := srvc.released[ctx.JobID]; ok {
+		srvc.mu.Unlock()
+		return nil, fmt.Errorf("jobcache: job %s already released",
+			ctx.JobID)
+	}
+	c, ok := srvc.caches[ctx.JobID]
+	if !ok {
+		c = &jobCache{paths: make(map[string]string)}
+		srvc.caches[ctx.JobID] = c
+	}
+	srvc.mu.Unlock()
+
+	c.Lock()
+	defer c.Unlock()
+	if err := srvc.fetchCache(a, c, ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReleaseJob is a RPC endpoint used by the master to notify the worker that a
+// job has finished. The hosts of the map outputs of the job are forgotten,
+// and the side files and the compiled module of the job are removed, unless
+// they are also used by other jobs. The logs of the job are removed once the
+// log retention period has elapsed since the job was released
+func (srvc *MapReduceService) ReleaseJob(jobID string, _ *Void) error {
+	if srvc.cfg.LogRetention > 0 && validLogName(jobID) {
+		time.AfterFunc(srvc.cfg.LogRetention, func() {
+			removeJobLogs(jobID)
+		})
+	}
+
+	srvc.mu.Lock()
+	now := time.Now()
+	for id, t := range srvc.released {
+		if now.Sub(t) > releasedTTL {
+			delete(srvc.released, id)
+		}
+	}
+	srvc.released[jobID] = now
+	c, ok := srvc.caches[jobID]
+	delete(srvc.caches, jobID)
+	delete(srvc.tsk2host, jobID)
+	delete(srvc.versions, jobID)
+	w := srvc.wasm
+	srvc.mu.Unlock()
+	if w != nil {
+		w.release(jobID)
+	}
+	if !ok {
+		return nil
+	}
+
+	// Wait for the side files being fetched, if any, and prevent the attempts
+	// holding the cache from fetching them again
+	c.Lock()
+	c.released = true
+	c.Unlock()
+	srvc.releaseCacheFiles(c.paths)
+	return nil
+}
@@ -0,0 +1,55 @@
+package workers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestJobCache(t *testing.T) {
+	data := []byte("workers cache test\n")
+	sum := sha256.Sum256(data)
+	file := CacheFile{Name: "words.txt", Checksum: hex.EncodeToString(sum[:]),
+		Size: int64(len(data))}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+	defer os.Remove(cachePath + file.Checksum)
+
+	srvc := MakeMapReduceService(Config{})
+	a := &attempt{ctx: context.Background()}
+	for _, jobID := range []string{"job_1", "job_2"} {
+		ctx := &RequestContext{JobID: jobID, Cache: []CacheFile{file},
+			CacheURL: srv.URL}
+		if _, err := srvc.jobCache(a, ctx); err != nil {
+			t.Skipf("cannot fetch side files: %v", err)
+		}
+	}
+
+	// Side files shared by several jobs are removed with the last job
+	srvc.ReleaseJob("job_1", nil)
+	if _, err := os.Stat(cachePath + file.Checksum); err != nil {
+		t.Errorf("shared side file removed: %v", err)
+	}
+	srvc.ReleaseJob("job_2", nil)
+	if _, err := os.Stat(cachePath + file.Checksum); !os.IsNotExist(err) {
+		t.Errorf("side file not removed: %v", err)
+	}
+
+	// Late attempts of released jobs do not fetch the side files again
+	ctx := &RequestContext{JobID: "job_1", Cache: []CacheFile{file},
+		CacheURL: srv.URL}
+	if _, err := srvc.jobCache(a, ctx); err == nil {
+		t.Errorf("expected error for released job")
+	}
+	if len(srvc.caches) != 0 || len(srvc.cacheRefs) != 0 {
+		t.Errorf("released job still tracked: %v, %v", srvc.caches,
+			srvc.cacheRefs)
+	}
+}
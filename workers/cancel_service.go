@@ -0,0 +1,35 @@
+package workers
+
+import "time"
+
+const (
+	// cancelledTTL is how long the cancellation of an attempt that has not
+	// started yet is remembered. Attempts starting later than that have been
+	// abandoned by the master anyway
+	cancelledTTL = 15 * time.Minute
+)
+
+// Cancel is a RPC endpoint used by the master to cancel a running task
+// attempt. The attempt stops at the next cancellation point, removes any
+// partially written file and reports a FAILED status. Cancelling an attempt
+// that has not started yet prevents it from running, unless it starts more
+// than cancelledTTL later
+func (srvc *MapReduceService) Cancel(attemptID string, _ *Void) error {
+	srvc.mu.Lock()
+	defer srvc.mu.Unlock()
+
+	if a, ok := srvc.attempts[attemptID]; ok {
+		a.cancel()
+		return nil
+	}
+
+	// Forget the cancellations of the attempts that never started
+	now := time.Now()
+	for id, when := range srvc.cancelled {
+		if now.Sub(when) > cancelledTTL {
+			delete(srvc.cancelled, id)
+		}
+	}
+	srvc.cancelled[attemptID] = now
+	return nil
+}
@@ -0,0 +1,39 @@
+package workers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCancel(t *testing.T) {
+	srvc := MakeMapReduceService(Config{})
+	ctx := &RequestContext{JobID: "job_cancel_test", AttemptID: "attempt_0_1"}
+	defer os.RemoveAll(filepath.Dir(taskLogPath(ctx.JobID, ctx.AttemptID)))
+
+	// Running attempts are cancelled
+	a, release := srvc.startAttempt(ctx)
+	srvc.Cancel(ctx.AttemptID, nil)
+	if a.ctx.Err() == nil {
+		t.Errorf("Running attempt not cancelled")
+	}
+	release()
+
+	// Attempts cancelled before starting do not run, and their cancellation
+	// is forgotten once they start
+	ctx.AttemptID = "attempt_0_2"
+	srvc.Cancel(ctx.AttemptID, nil)
+	a, release = srvc.startAttempt(ctx)
+	if a.ctx.Err() == nil || len(srvc.cancelled) != 0 {
+		t.Errorf("Attempt cancelled before starting not cancelled")
+	}
+	release()
+
+	// Cancellations of attempts that never start expire
+	srvc.cancelled["attempt_1_1"] = time.Now().Add(-2 * cancelledTTL)
+	srvc.Cancel("attempt_2_1", nil)
+	if _, ok := srvc.cancelled["attempt_1_1"]; ok || len(srvc.cancelled) != 1 {
+		t.Errorf("Expired cancellation not removed: %v", srvc.cancelled)
+	}
+}
@@ -2,12 +2,11 @@ package workers
 
 import (
 	"container/list"
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"time"
 
@@ -17,9 +16,9 @@ import (
 )
 
 const (
-	reducerPath = "/Users/giulioborghesi/tmp/reducer/"
-	maxAttempts = 16
-	idle        = iota
+	reducerPath         = "/Users/giulioborghesi/tmp/reducer/"
+	sourcesTimeoutInSec = 600
+	idle                = iota
 	done
 	failed
 )
@@ -38,7 +37,9 @@ type dataSource struct {
 // The provisioner will contact the hosts storing the data through HTTP
 // requests and download it
 type dataProvisioner struct {
-	file    string
+	ctx     context.Context
+	a       *attempt
+	jobID   string
 	idx     int
 	sources map[int]*dataSource
 	queue   list.List
@@ -46,11 +47,14 @@ type dataProvisioner struct {
 }
 
 // makeDataProvisioner initializes the data provisioner from a request context
-// object and a MapReduce service instance
-func makeDataProvisioner(ctx *RequestContext,
+// object and a MapReduce service instance. Data provisioning is interrupted
+// when the task attempt is cancelled, and the fraction of data provisioned is
+// recorded in the attempt progress
+func makeDataProvisioner(a *attempt, ctx *RequestContext,
 	srvc *MapReduceService) *dataProvisioner {
-	p := &dataProvisioner{file: ctx.File, sources: make(map[int]*dataSource),
-		idx: ctx.Idx, srvc: srvc}
+	p := &dataProvisioner{ctx: a.ctx, a: a, jobID: ctx.JobID,
+		sources: make(map[int]*dataSource), idx: ctx.Idx, srvc: srvc}
+	a.sources.Store(int64(ctx.MapperCnt))
 	for i := 0; i < ctx.MapperCnt; i++ {
 		p.sources[i] = &dataSource{idx: i, status: idle}
 	}
@@ -70,8 +74,10 @@ func (p *dataProvisioner) dataSource(idx int) dataSource {
 	return *p.sources[idx]
 }
 
-// fetchData downloads the requested file from a data source
-func (p *dataProvisioner) fetchData(src dataSource) string {
+// fetchData downloads the requested map output from a data source and hands
+// it over to the shuffle merger. It returns true on success and false
+// otherwise
+func (p *dataProvisioner) fetchData(src dataSource, m *shuffleMerger) bool {
 	// Set source status on return
 	var s sourceStatus = failed
 	defer func() {
@@ -80,52 +86,51 @@ func (p *dataProvisioner) fetchData(src dataSource) string {
 
 	// Construct URL
 	host := string(src.host)
-	dataPath := "data/" + utils.GetIntermediateFilePrefix(p.file, src.idx) +
+	dataPath := "data/" + utils.GetIntermediateFilePrefix(p.jobID, src.idx) +
 		"." + strconv.Itoa(p.idx)
 	u := url.URL{Host: host, Scheme: "http", Path: dataPath}
 
 	// Fetch file through HTTP
-	resp, err := http.Get(u.String())
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, u.String(),
+		nil)
 	if err != nil {
-		return ""
+		return false
 	}
-	defer resp.Body.Close()
-
-	// Create output file. File closure not deferred intentionally
-	filePath := reducerPath + utils.GetIntermediateFilePrefix(p.file, p.idx) +
-		"." + strconv.Itoa(src.idx)
 
-	f, err := os.Create(filePath)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return ""
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false
 	}
 
-	// Copy data to local file and return
-	_, err = io.Copy(f, resp.Body)
-	f.Close()
-	if err != nil {
-		os.Remove(filePath)
-		return ""
+	// Store data in memory or on disk and return
+	if err := m.add(resp.Body, resp.ContentLength); err != nil {
+		return false
 	}
 	s = done
-	return filePath
+	return true
 }
 
 // fetchDataFromSources downloads all the files for which a data source is
-// available
-func (p *dataProvisioner) fetchDataFromSources() []string {
-	res := make([]string, 0)
+// available and returns the number of files successfully downloaded
+func (p *dataProvisioner) fetchDataFromSources(m *shuffleMerger) int {
+	cnt := 0
 	for {
 		if p.queue.Len() == 0 {
 			break
 		}
 
 		src := p.dataSource(p.nextTask())
-		if path := p.fetchData(src); path != "" {
-			res = append(res, path)
+		if p.fetchData(src, m) {
+			p.a.fetched.Add(1)
+			cnt++
 		}
 	}
-	return res
+	return cnt
 }
 
 // nexttask fetches the next task source to be provisioned. The method will
@@ -140,45 +145,71 @@ func (p *dataProvisioner) nextTask() int {
 	return src
 }
 
-// provisionData provisions the data stored in the remote hosts and returns a
-// list of paths to the locally stored data files on success.
-func (p *dataProvisioner) provisionData() ([]string, error) {
-	paths := []string{}
+// provisionData provisions the data stored in the remote hosts and hands it
+// over to the shuffle merger. Map outputs are fetched as soon as they become
+// available, so that the shuffle can overlap with the map phase
+func (p *dataProvisioner) provisionData(m *shuffleMerger) error {
+	cnt := 0
 
 	for {
 		// Download available files
-		paths = append(paths, p.fetchDataFromSources()...)
-		if len(paths) == len(p.sources) {
+		cnt += p.fetchDataFromSources(m)
+		if err := p.ctx.Err(); err != nil {
+			return err
+		}
+		if cnt == len(p.sources) {
 			break
 		}
 
-		// Attempt to update data sources and fail if unsuccessful
-		for i, fact := 0, 1; i <= maxAttempts; i++ {
-			for idx, source := range p.sources {
-				if source.status == done {
-					continue
-				}
-
-				host := p.srvc.host(p.file, idx)
-				if source.host == host || host == "" {
-					continue
-				}
-
-				source.host = host
-				source.status = idle
-				p.addTask(idx)
-			}
-
-			if p.queue.Len() > 0 {
-				break
-			}
-
-			if i == maxAttempts {
-				return nil, errors.New("provisiondata: data unavailable")
-			}
-			time.Sleep(time.Duration(fact) * time.Millisecond)
-			fact *= 2
+		// Wait for new data sources and fail if none becomes available
+		if !p.waitForSources() {
+			return errors.New("provisiondata: data unavailable")
+		}
+	}
+	return nil
+}
+
+// updateSources adds to the download queue the map outputs whose host has
+// changed since the last download attempt
+func (p *dataProvisioner) updateSources() {
+	for idx, source := range p.sources {
+		if source.status == done {
+			continue
+		}
+
+		host := p.srvc.host(p.jobID, idx)
+		if source.host == host || host == "" {
+			continue
+		}
+
+		source.host = host
+		source.status = idle
+		p.addTask(idx)
+	}
+}
+
+// waitForSources blocks until new data sources become available. It returns
+// true on success and false if no data source became available in time or
+// data provisioning was interrupted
+func (p *dataProvisioner) waitForSources() bool {
+	timer := time.NewTimer(sourcesTimeoutInSec * time.Second)
+	defer timer.Stop()
+
+	for {
+		// Subscribe to updates before checking for new data sources, so that
+		// no update is missed
+		updated := p.srvc.sourcesUpdated()
+		p.updateSources()
+		if p.queue.Len() > 0 {
+			return true
+		}
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return false
+		case <-p.ctx.Done():
+			return false
 		}
 	}
-	return paths, nil
 }
@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/giulioborghesi/mapreduce/common"
@@ -29,6 +30,10 @@ func SendData(w http.ResponseWriter, r *http.Request) {
 	}
 	defer f.Close()
 
+	// Advertise file size so that consumers can decide where to store data
+	if info, err := f.Stat(); err == nil {
+		w.Header().Add("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
 	w.Header().Add("Content-Type", "application/octet-stream")
 	_, err = io.Copy(w, f)
 	if err != nil {
@@ -38,18 +43,32 @@ func SendData(w http.ResponseWriter, r *http.Request) {
 }
 
 // UpdateSources updates the mapping from mapper task idx to worker address
-// with the latest information received from the master
+// with the latest changes received from the master. Changes are applied only
+// if the worker has seen all the changes that precede them; the version of
+// the data sources known to the worker is returned to the master, which will
+// then send the missing changes
 func (srvc *MapReduceService) UpdateSources(ctx *UpdateRequestContext,
-	_ *Void) error {
+	reply *UpdateReply) error {
 	srvc.mu.Lock()
 	defer srvc.mu.Unlock()
 
-	if _, ok := srvc.tsk2host[ctx.File]; !ok {
-		srvc.tsk2host[ctx.File] = make(map[int]common.Host)
+	if _, ok := srvc.tsk2host[ctx.JobID]; !ok {
+		srvc.tsk2host[ctx.JobID] = make(map[int]common.Host)
+	}
+
+	reply.Version = srvc.versions[ctx.JobID]
+	if ctx.Since > reply.Version || ctx.Version <= reply.Version {
+		return nil
 	}
 
 	for idx, host := range ctx.Hosts {
-		srvc.tsk2host[ctx.File][idx] = host
+		srvc.tsk2host[ctx.JobID][idx] = host
 	}
+	srvc.versions[ctx.JobID] = ctx.Version
+	reply.Version = ctx.Version
+
+	// Wake up the reduce tasks waiting for new data sources
+	close(srvc.updated)
+	srvc.updated = make(chan Void)
 	return nil
 }
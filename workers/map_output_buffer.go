@@ -0,0 +1,224 @@
+package workers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/giulioborghesi/mapreduce/roles"
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+const (
+	// recordOverhead is the estimated amount of memory used by a buffered
+	// key / value pair in addition to its key and value
+	recordOverhead = 48
+)
+
+// bufferedRecord is a key / value pair stored in a map output buffer, along
+// with the partition it is assigned to
+type bufferedRecord struct {
+	part       int
+	key, value string
+}
+
+// mapOutputBuffer collects the intermediate key / value pairs emitted by a Map
+// task. Pairs are assigned to a partition as soon as they are emitted. When
+// the memory budget is exhausted, pairs are sorted by partition and key and
+// spilled to disk, one file per partition. Pairs with the same key keep the
+// order in which they were emitted
+type mapOutputBuffer struct {
+	prog    roles.Program
+	parts   int
+	budget  int64
+	used    int64
+	records []bufferedRecord
+	prefix  string
+	spills  int
+	paths   []string
+}
+
+// makeMapOutputBuffer creates a new mapOutputBuffer object that splits the
+// pairs into the specified number of partitions. Spills are stored in files
+// whose name starts with prefix. A non-positive memory budget disables
+// spilling
+func makeMapOutputBuffer(prog roles.Program, parts int, budget int64,
+	prefix string) *mapOutputBuffer {
+	return &mapOutputBuffer{prog: prog, parts: parts, budget: budget,
+		prefix: prefix}
+}
+
+// Emit adds a key / value pair to the buffer, and spills the buffer to disk
+// if the memory budget is exhausted
+func (b *mapOutputBuffer) Emit(key, value string) error {
+	b.records = append(b.records, bufferedRecord{
+		part: b.prog.Partition(key, b.parts), key: key, value: value})
+	b.used += int64(len(key)+len(value)) + recordOverhead
+	if b.budget > 0 && b.used >= b.budget {
+		return b.spill()
+	}
+	return nil
+}
+
+// sort sorts the buffered pairs by partition and key
+func (b *mapOutputBuffer) sort() {
+	sort.SliceStable(b.records, func(i, j int) bool {
+		ri, rj := b.records[i], b.records[j]
+		if ri.part != rj.part {
+			return ri.part < rj.part
+		}
+		return b.prog.Order.Less(ri.key, rj.key)
+	})
+}
+
+// partition removes the pairs of the specified partition from the head of
+// the sorted buffered pairs and returns them
+func (b *mapOutputBuffer) partition(part int) []bufferedRecord {
+	n := 0
+	for n < len(b.records) && b.records[n].part == part {
+		n++
+	}
+	res := b.records[:n]
+	b.records = b.records[n:]
+	return res
+}
+
+// spillPath returns the path of the file storing a partition of a spill
+func (b *mapOutputBuffer) spillPath(spill, part int) string {
+	return b.prefix + ".spill." + strconv.Itoa(spill) + "." +
+		strconv.Itoa(part)
+}
+
+// spill sorts the buffered pairs, writes them to disk and releases the memory
+// used by them
+func (b *mapOutputBuffer) spill() error {
+	b.sort()
+	for part := 0; part < b.parts; part++ {
+		path := b.spillPath(b.spills, part)
+		b.paths = append(b.paths, path)
+		if err := writeRecords(path, b.partition(part)); err != nil {
+			return err
+		}
+	}
+	b.spills++
+	b.records = nil
+	b.used = 0
+	return nil
+}
+
+// mergeSpills merges a partition of all the spills into the file at path
+func (b *mapOutputBuffer) mergeSpills(path string, part int) error {
+	paths := make([]string, 0, b.spills)
+	for spill := 0; spill < b.spills; spill++ {
+		paths = append(paths, b.spillPath(spill, part))
+	}
+	fs, err := openFiles(paths)
+	if err != nil {
+		return err
+	}
+	defer closeFiles(fs)
+
+	rs := make([]io.Reader, 0, len(fs))
+	for _, f := range fs {
+		rs = append(rs, f)
+	}
+	return writeFile(path, func(w io.Writer) error {
+		return utils.MergeKeyValues(w, b.prog.Order, rs...)
+	})
+}
+
+// commit writes each partition of the map output to an intermediate file.
+// Partitions are first written to attempt-specific temporary files, either
+// directly from memory or by merging the spills, and then moved to their
+// final location once all of them have been written, unless the attempt has
+// been cancelled in the meantime. Temporary files are removed on failure
+func (b *mapOutputBuffer) commit(ctx context.Context, nameBase,
+	attemptID string) error {
+	if b.spills > 0 && len(b.records) > 0 {
+		if err := b.spill(); err != nil {
+			return err
+		}
+	}
+	b.sort()
+
+	// Remove temporary files on return
+	tmpPaths := make([]string, 0, b.parts)
+	defer func() {
+		for _, path := range tmpPaths {
+			os.Remove(path)
+		}
+	}()
+
+	// Write one file for each partition of the key / value pairs
+	for part := 0; part < b.parts; part++ {
+		path := intermediateFilePath(nameBase, part) + "." + attemptID
+		tmpPaths = append(tmpPaths, path)
+
+		var err error
+		if b.spills > 0 {
+			err = b.mergeSpills(path, part)
+		} else {
+			err = writeRecords(path, b.partition(part))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Commit files unless the attempt has been cancelled
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for part, path := range tmpPaths {
+		err := os.Rename(path, intermediateFilePath(nameBase, part))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close releases the memory used by the buffer and removes the spills
+func (b *mapOutputBuffer) close() {
+	for _, path := range b.paths {
+		os.Remove(path)
+	}
+	b.paths = nil
+	b.records = nil
+	b.used = 0
+}
+
+// writeRecords writes key / value pairs to the file at path in the format of
+// the intermediate files
+func writeRecords(path string, records []bufferedRecord) error {
+	return writeFile(path, func(w io.Writer) error {
+		writer := bufio.NewWriter(w)
+		for _, r := range records {
+			if err := utils.WriteKeyValue(writer, r.key, r.value); err != nil {
+				return err
+			}
+		}
+		return writer.Flush()
+	})
+}
+
+// writeFile creates the file at path, whose content is generated by write.
+// The file is removed on error
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
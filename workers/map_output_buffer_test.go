@@ -0,0 +1,65 @@
+package workers
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/giulioborghesi/mapreduce/roles"
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+func TestMapOutputBufferSpill(t *testing.T) {
+	prog := roles.Program{Partition: func(key string, parts int) int {
+		return int(key[0]) % parts
+	}}
+	dir := t.TempDir()
+	buf := makeMapOutputBuffer(prog, 2, 3*recordOverhead,
+		filepath.Join(dir, "out"))
+	defer buf.close()
+
+	for _, key := range []string{"d", "b", "a", "b", "c", "a", "b"} {
+		if err := buf.Emit(key, "1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := buf.spill(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.spills != 3 {
+		t.Errorf("expected spills: 3, actual: %d", buf.spills)
+	}
+
+	expected := []string{"bbbd", "aac"}
+	for part := range expected {
+		path := filepath.Join(dir, "part")
+		if err := buf.mergeSpills(path, part); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keys := ""
+		reader := bufio.NewReader(f)
+		for {
+			key, _, err := utils.ReadKeyValue(reader)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			keys += key
+		}
+		f.Close()
+
+		if keys != expected[part] {
+			t.Errorf("partition %d: expected: %s, actual: %s", part,
+				expected[part], keys)
+		}
+	}
+}
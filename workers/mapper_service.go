@@ -1,12 +1,10 @@
 package workers
 
 import (
-	"bufio"
 	"io"
-	"os"
-	"sort"
 	"strconv"
 
+	"github.com/giulioborghesi/mapreduce/formats"
 	"github.com/giulioborghesi/mapreduce/roles"
 	"github.com/giulioborghesi/mapreduce/utils"
 )
@@ -15,93 +13,153 @@ const (
 	mapperPath = "/Users/giulioborghesi/tmp/mapper/"
 )
 
-// writeFile writes the intermediate key / value pairs to file. The filename
-// has the following format: {task index}.{producer index}.
-func writeFile(kvPairs map[string][]string, nameBase string,
-	fileIdx int) error {
-	path := mapperPath + nameBase + "." + strconv.Itoa(fileIdx)
-	f, err := os.Create(path)
+// intermediateFilePath returns the path of the intermediate file storing a
+// partition of the map output. The filename has the following format:
+// {task index}.{producer index}.
+func intermediateFilePath(nameBase string, part int) string {
+	return mapperPath + nameBase + "." + strconv.Itoa(part)
+}
+
+// mapRecords applies the map function to the records read by rr, tagged
+// with the source of the task input, and passes the emitted key / value
+// pairs to out. Records are no longer read once the attempt has been
+// cancelled
+func mapRecords(a *attempt, prog roles.Program, rr formats.RecordReader,
+	source string, out roles.Emitter[string, string]) error {
+	for a.ctx.Err() == nil {
+		rec, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+		rec.Source = source
+
+		if err := prog.Map(rec, out); err != nil {
+			return err
+		}
+		a.bytesRead.Store(rr.BytesRead())
+		a.records.Add(1)
+	}
+	return nil
+}
+
+// mapOnly applies the map function to the input records of a map-only job and
+// writes the emitted key / value pairs directly to the job output, in the
+// order in which they are emitted. The output, together with the named
+// outputs of the attempt, is left for the master to commit only if all the
+// records have been processed and the attempt has not been cancelled, and is
+// aborted otherwise
+func mapOnly(ctx *RequestContext, a *attempt, named *namedOutputs,
+	run func(roles.Emitter[string, string]) error,
+	format func(string, string) (string, string, error)) error {
+	out, err := openTaskOutput(ctx, mapOutput, named, format)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	closed := false
+	defer func() {
+		if !closed {
+			out.close(false)
+		}
+	}()
 
-	// Sort keys
-	sortedKeys := make([]string, 0, len(kvPairs))
-	for key := range kvPairs {
-		sortedKeys = append(sortedKeys, key)
+	if err := run(out); err != nil {
+		return err
 	}
-	sort.StringSlice(sortedKeys).Sort()
-
-	// Write data to file, sorted by keys
-	writer := bufio.NewWriter(f)
-	for _, key := range sortedKeys {
-		for _, value := range kvPairs[key] {
-			line := key + " " + value + "\n"
-			_, err := writer.WriteString(line)
-			if err != nil {
-				return err
-			}
-		}
+	if a.ctx.Err() != nil {
+		return nil
 	}
-	writer.Flush()
-	return nil
+	closed = true
+	return out.close(true)
 }
 
-// writeIntermediateFiles partitions the key / value pairs into partitions
-// based on a user-supplied partition functions. Individual partitions are
-// then written to file by calling writeFile
-func writeIntermediateFiles(kvPairs map[string][]string, nameBase string,
-	parts int) error {
-	// Partition key / value pairs
-	splitKvPairs := make(map[int]map[string][]string)
-	for k, v := range kvPairs {
-		i := roles.Partition(k, parts)
-		if _, ok := splitKvPairs[i]; !ok {
-			splitKvPairs[i] = make(map[string][]string)
-		}
-		splitKvPairs[i][k] = v
+// Map implements a MapReduce map service endpoint. The service reads the
+// records of an input split using the job input format and generates an
+// intermediate file of sorted key-value pairs for each Reducer task. The
+// emitted pairs are buffered in memory and spilled to disk when the sort
+// memory budget is exhausted. The Map tasks of a map-only job write their
+// output directly to the job output instead, without sorting it. The job
+// output and the named outputs written by a Map task are committed by the
+// master once the task completes. A Map task is successful unless it is
+// cancelled by the master, in which case its status is FAILED, or an
+// irreversible error occur; in that case, however, the return status is
+// ignored and thus its value is irrelevant
+func (srvc *MapReduceService) Map(ctx *RequestContext, r *TaskReply) error {
+	r.Status = SUCCESS
+	a, release := srvc.startAttempt(ctx)
+	defer release()
+	defer func() {
+		r.Counters = a.progress()
+		a.finish(ctx, r)
+	}()
+	a.logger().Info("map task started", "index", ctx.Idx)
+
+	named := makeNamedOutputs(ctx, mapOutput)
+	defer named.close(false)
+	prog, release, err := srvc.taskProgram(ctx, a, named)
+	if err != nil {
+		return failAttempt(r, err)
 	}
+	defer release()
 
-	// Write one file for each partition of the key / value pairs
-	for i := 0; i < parts; i++ {
-		if err := writeFile(splitKvPairs[i], nameBase, i); err != nil {
-			return err
-		}
+	format, err := formats.LookupInputFormat(ctx.InputFormat)
+	if err != nil {
+		return err
 	}
-	return nil
-}
 
-// Map implements a MapReduce map service endpoint. The service takes as input
-// a path to a file containing a list of input records and generates an
-// intermediate file of sorted key-value pairs. A Map task cannot be preempted
-// and thus is always successfull, unless an irreversible error occur; in that
-// case, however, the return status is ignored and thus its value is irrelevant
-func (srvc *MapReduceService) Map(ctx *RequestContext, s *Status) error {
-	*s = SUCCESS
-	f, err := os.Open(ctx.File)
+	rr, err := format.Reader(ctx.Split)
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
-	reader := bufio.NewReader(f)
+	defer rr.Close()
+	a.bytesTotal.Store(ctx.Split.Length)
 
-	kvPairs := make(map[string][]string)
-	mapper := roles.Mapper{}
-	for {
-		l, err := reader.ReadString('\n')
-		if err == io.EOF {
-			break
+	// Records are mapped by the program, or by the command of streaming jobs
+	run := func(out roles.Emitter[string, string]) error {
+		return mapRecords(a, prog, rr, ctx.Source, out)
+	}
+	outFormat := prog.Format
+	if ctx.Streaming != nil {
+		run = func(out roles.Emitter[string, string]) error {
+			return streamMap(ctx, a, rr, out)
 		}
+		outFormat = nil
+	}
 
-		if err != nil {
-			return err
+	// Map-only jobs skip partitioning, sorting and shuffle
+	if ctx.ReducerCnt == 0 {
+		err := mapOnly(ctx, a, named, run, outFormat)
+		if a.ctx.Err() != nil {
+			r.Status = FAILED
+			return nil
 		}
+		return failAttempt(r, err)
+	}
 
-		mapper.Map(l, kvPairs)
+	nameBase := utils.GetIntermediateFilePrefix(ctx.JobID, ctx.Idx)
+	buf := makeMapOutputBuffer(prog, ctx.ReducerCnt, srvc.cfg.SortMemoryBytes,
+		mapperPath+nameBase+"."+ctx.AttemptID)
+	defer buf.close()
+	err = run(buf)
+	if a.ctx.Err() != nil {
+		r.Status = FAILED
+		return nil
+	}
+	if err != nil {
+		return failAttempt(r, err)
 	}
 
-	nameBase := utils.GetIntermediateFilePrefix(ctx.File, ctx.Idx)
-	return writeIntermediateFiles(kvPairs, nameBase, ctx.ReducerCnt)
+	err = buf.commit(a.ctx, nameBase, ctx.AttemptID)
+	if a.ctx.Err() != nil {
+		r.Status = FAILED
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return named.close(true)
 }
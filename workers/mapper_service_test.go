@@ -0,0 +1,62 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/roles"
+)
+
+func TestMapOnly(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	committer := formats.FileOutputCommitter{}
+	if err := committer.SetupJob(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a := &attempt{ctx: context.Background()}
+	exclaim := func(key, value string) (string, string, error) {
+		return key, value + "!", nil
+	}
+
+	// Failed attempts leave no output behind
+	ctx := &RequestContext{Idx: 1, Output: dir, AttemptID: "attempt_1_1"}
+	err := mapOnly(ctx, a, makeNamedOutputs(ctx, mapOutput),
+		func(out roles.Emitter[string, string]) error {
+			out.Emit("a", "1")
+			return errors.New("map failed")
+		}, exclaim)
+	if err == nil {
+		t.Errorf("expected error from failed attempt")
+	}
+
+	// Successful attempts leave the formatted pairs, in emission order, for
+	// the master to commit
+	ctx.AttemptID = "attempt_1_2"
+	err = mapOnly(ctx, a, makeNamedOutputs(ctx, mapOutput),
+		func(out roles.Emitter[string, string]) error {
+			out.Emit("b", "2")
+			return out.Emit("a", "1")
+		}, exclaim)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, formats.TemporaryDir))
+	if len(entries) != 1 || entries[0].Name() != ctx.AttemptID {
+		t.Fatalf("unexpected attempt directories: %v", entries)
+	}
+	if err := committer.CommitTask(dir, ctx.AttemptID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "part-m-00001"))
+	if err != nil || string(data) != "b 2!\na 1!\n" {
+		t.Errorf("unexpected output: %q, %v", data, err)
+	}
+	entries, _ = os.ReadDir(filepath.Join(dir, formats.TemporaryDir))
+	if len(entries) != 0 {
+		t.Errorf("attempt directories not removed: %v", entries)
+	}
+}
@@ -0,0 +1,216 @@
+package workers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/roles"
+)
+
+const (
+	// mapOutput is the kind of the output files written by the Mapper tasks
+	// of map-only jobs
+	mapOutput = 'm'
+	// reduceOutput is the kind of the output files written by Reducer tasks
+	reduceOutput = 'r'
+)
+
+// outputFileName returns the name of the output file of a task of the
+// specified kind
+func outputFileName(kind byte, idx int) string {
+	return fmt.Sprintf("part-%c-%05d", kind, idx)
+}
+
+// taskOutput writes the key / value pairs emitted by a task attempt to the
+// job output, using the job output format. Pairs are formatted using format
+// first, if not nil. The pairs are written to a file in the attempt
+// directory, which the master commits to the job output together with the
+// named outputs of the attempt once the attempt succeeds, or to standard
+// output if the job has no output directory
+type taskOutput struct {
+	ctx       *RequestContext
+	committer formats.OutputCommitter
+	f         *os.File
+	rw        formats.RecordWriter
+	format    func(key, value string) (string, string, error)
+	named     *namedOutputs
+}
+
+// openTaskOutput creates the output of a task attempt of the specified kind
+func openTaskOutput(ctx *RequestContext, kind byte, named *namedOutputs,
+	format func(string, string) (string, string, error)) (*taskOutput,
+	error) {
+	path := ""
+	if ctx.Output != "" {
+		path = outputFileName(kind, ctx.Idx)
+	}
+	return openOutputFile(ctx, path, ctx.OutputFormat, format, named)
+}
+
+// openOutputFile creates a file in the attempt directory, at the specified
+// path relative to the directory, and writes to it using the output format
+// named outputFormat. Standard output is used if the path is empty. Files
+// that cannot be created are removed with the attempt directory when the
+// attempt output is committed or aborted
+func openOutputFile(ctx *RequestContext, path, outputFormat string,
+	format func(string, string) (string, string, error),
+	named *namedOutputs) (*taskOutput, error) {
+	of, err := formats.LookupOutputFormat(outputFormat)
+	if err != nil {
+		return nil, err
+	}
+	committer, err := outputCommitter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &taskOutput{ctx: ctx, committer: committer, f: os.Stdout,
+		format: format, named: named}
+	if path != "" {
+		dir, err := o.committer.SetupTask(ctx.Output, ctx.AttemptID)
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if o.f, err = os.Create(path); err != nil {
+			return nil, err
+		}
+	}
+	o.rw = of.Writer(o.f)
+	return o, nil
+}
+
+// outputCommitter returns the committer of the output of a job, that is the
+// committer of the job output format. Named outputs are committed by the
+// same committer, whatever their output format
+func outputCommitter(ctx *RequestContext) (formats.OutputCommitter, error) {
+	of, err := formats.LookupOutputFormat(ctx.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	return of.Committer(), nil
+}
+
+// Emit writes a key / value pair to the task output
+func (o *taskOutput) Emit(key, value string) error {
+	if o.format != nil {
+		var err error
+		if key, value, err = o.format(key, value); err != nil {
+			return err
+		}
+	}
+	return o.rw.Write(key, value)
+}
+
+// flush flushes the task output and closes its file
+func (o *taskOutput) flush() error {
+	err := o.rw.Close()
+	if o.f == os.Stdout {
+		return err
+	}
+
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// close flushes the task output and the named outputs of the attempt. The
+// outputs of a successful attempt are left in the attempt directory for the
+// master to commit, while those of a failed attempt, or that cannot be
+// flushed, are aborted
+func (o *taskOutput) close(success bool) error {
+	err := o.flush()
+	if o.named != nil {
+		if nerr := o.named.flush(); err == nil {
+			err = nerr
+		}
+	}
+	if o.f == os.Stdout {
+		return err
+	}
+
+	if err != nil || !success {
+		o.committer.AbortTask(o.ctx.Output, o.ctx.AttemptID)
+	}
+	return err
+}
+
+// namedOutputs writes the named outputs of a task attempt. The file of a
+// named output is created in a subdirectory of the attempt directory named
+// after the output the first time the output is used, and is committed to
+// the job output by the master together with the main output of the attempt
+type namedOutputs struct {
+	ctx     *RequestContext
+	kind    byte
+	outputs map[string]*taskOutput
+}
+
+// makeNamedOutputs creates the named outputs of a task attempt of the
+// specified kind
+func makeNamedOutputs(ctx *RequestContext, kind byte) *namedOutputs {
+	return &namedOutputs{ctx: ctx, kind: kind,
+		outputs: make(map[string]*taskOutput)}
+}
+
+// Output returns the emitter writing to a named output of the job
+func (n *namedOutputs) Output(name string) (roles.Emitter[string, string],
+	error) {
+	if o, ok := n.outputs[name]; ok {
+		return o, nil
+	}
+
+	format, ok := n.ctx.NamedOutputs[name]
+	if !ok {
+		return nil, fmt.Errorf("output: unknown named output: %s", name)
+	}
+	if n.ctx.Output == "" {
+		return nil, fmt.Errorf("output: job has no output directory")
+	}
+
+	path := filepath.Join(name, outputFileName(n.kind, n.ctx.Idx))
+	o, err := openOutputFile(n.ctx, path, format, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	n.outputs[name] = o
+	return o, nil
+}
+
+// flush flushes the named outputs used by the attempt
+func (n *namedOutputs) flush() error {
+	var err error
+	for name, o := range n.outputs {
+		if ferr := o.flush(); err == nil && ferr != nil {
+			err = fmt.Errorf("flush: named output %s: %v", name, ferr)
+		}
+	}
+	n.outputs = make(map[string]*taskOutput)
+	return err
+}
+
+// close flushes the named outputs used by an attempt that has no main
+// output, such as a Mapper task of a job with Reducer tasks. The outputs of
+// a successful attempt are left for the master to commit, while those of a
+// failed attempt are aborted
+func (n *namedOutputs) close(success bool) error {
+	used := len(n.outputs) > 0
+	err := n.flush()
+	if !used {
+		return err
+	}
+
+	committer, cerr := outputCommitter(n.ctx)
+	if cerr != nil {
+		return cerr
+	}
+	if err != nil || !success {
+		committer.AbortTask(n.ctx.Output, n.ctx.AttemptID)
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package workers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+)
+
+func TestNamedOutputs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	ctx := &RequestContext{Idx: 3, Output: dir, AttemptID: "attempt_7_1",
+		NamedOutputs: map[string]string{"rejected": formats.JSONLinesFormat}}
+	committer := formats.FileOutputCommitter{}
+	if err := committer.SetupJob(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	named := makeNamedOutputs(ctx, reduceOutput)
+	out, err := openTaskOutput(ctx, reduceOutput, named, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rejected, err := named.Output("rejected")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := named.Output("unknown"); err == nil {
+		t.Errorf("expected error for unknown named output")
+	}
+
+	// Named outputs are committed by the master together with the main
+	// output
+	if err := out.Emit("a", "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rejected.Emit("b", "x"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := out.close(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := committer.CommitTask(dir, ctx.AttemptID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for path, expected := range map[string]string{
+		"part-r-00003":          "a 1\n",
+		"rejected/part-r-00003": "{\"key\":\"b\",\"value\":\"x\"}\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil || string(data) != expected {
+			t.Errorf("%s: expected: %q, actual: %q, error: %v", path,
+				expected, data, err)
+		}
+	}
+}
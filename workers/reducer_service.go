@@ -2,8 +2,6 @@ package workers
 
 import (
 	"fmt"
-	"io"
-	"os"
 
 	"github.com/giulioborghesi/mapreduce/roles"
 	"github.com/giulioborghesi/mapreduce/utils"
@@ -12,52 +10,94 @@ import (
 // Reduce implements a MapReduce reduce service endpoint. The service processes
 // a set of data sources and generates a file of sorted key-value pairs. A
 // Reduce task can fail when the intermediate files are not available for too
-// many times in a row
-func (srvc *MapReduceService) Reduce(ctx *RequestContext, s *Status) error {
+// long, or when it is cancelled by the master
+func (srvc *MapReduceService) Reduce(ctx *RequestContext,
+	r *TaskReply) error {
 	// Initialize return status
-	*s = FAILED
+	r.Status = FAILED
+	a, release := srvc.startAttempt(ctx)
+	defer release()
+	defer func() {
+		r.Counters = a.progress()
+		a.finish(ctx, r)
+	}()
+	a.logger().Info("reduce task started", "index", ctx.Idx)
 
-	// Provision data
-	p := makeDataProvisioner(ctx, srvc)
-	paths, err := p.provisionData()
+	named := makeNamedOutputs(ctx, reduceOutput)
+	defer named.close(false)
+	prog, release, err := srvc.taskProgram(ctx, a, named)
 	if err != nil {
-		return nil
+		return failAttempt(r, err)
+	}
+	defer release()
+	if prog.Reduce == nil && ctx.Streaming == nil {
+		return fmt.Errorf("reduce: program %s has no reduce function",
+			ctx.Program)
 	}
 
-	// Open files
-	its := []io.Reader{}
-	for _, path := range paths {
-		f, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		its = append(its, f)
+	// Provision data. Map outputs are merged in memory and spilled to disk
+	// only when the shuffle memory budget is exhausted
+	prefix := reducerPath + utils.GetIntermediateFilePrefix(ctx.JobID,
+		ctx.Idx) + "." + ctx.AttemptID
+	m := makeShuffleMerger(srvc.cfg.ShuffleMemoryBytes, prog.Order, prefix)
+	defer m.close()
+
+	p := makeDataProvisioner(a, ctx, srvc)
+	if err := p.provisionData(m); err != nil {
+		return nil
 	}
 
 	// Create key-values iterator
-	kvIt, err := utils.MakeKeyValueIterator(its...)
+	kvIt, fs, err := m.iterator()
 	if err != nil {
 		return err
 	}
+	defer closeFiles(fs)
 
-	reducer := roles.Reducer{}
-	for {
-		// Check if all data has been processed
-		if kvIt.HasNext() == false {
-			break
+	// Create task output and abort it, with the named outputs, on failure
+	out, err := openTaskOutput(ctx, reduceOutput, named, nil)
+	if err != nil {
+		return err
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			out.close(false)
 		}
-		key, vIt := kvIt.Next()
+	}()
+
+	// Reduce values and write the emitted pairs to the task output. Streaming
+	// jobs pipe the sorted key-value pairs into the reduce command instead
+	if ctx.Streaming != nil {
+		err = streamReduce(ctx, a, kvIt, out)
+	} else {
+		err = reduceKeys(a, prog, kvIt, out)
+	}
+	if a.ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return failAttempt(r, err)
+	}
+	closed = true
+	if err := out.close(true); err != nil {
+		return err
+	}
+	r.Status = SUCCESS
+	return nil
+}
 
-		// Reduce values
-		res, err := reducer.Reduce(key, vIt)
-		if err != nil {
+// reduceKeys applies the reduce function to each group of keys and passes the
+// emitted key / value pairs to out. Groups are no longer reduced once the
+// attempt has been cancelled
+func reduceKeys(a *attempt, prog roles.Program, kvIt *utils.KeyValueIterator,
+	out roles.Emitter[string, string]) error {
+	for kvIt.HasNext() && a.ctx.Err() == nil {
+		key, vIt := kvIt.Next()
+		if err := prog.Reduce(key, vIt, out); err != nil {
 			return err
 		}
-
-		// Print values. TODO: remove and store to file
-		fmt.Printf("%s: %s\n", key, res)
+		a.keys.Add(1)
 	}
-	*s = SUCCESS
 	return nil
 }
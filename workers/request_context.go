@@ -1,19 +1,56 @@
 package workers
 
-import "github.com/giulioborghesi/mapreduce/common"
+import (
+	"github.com/giulioborghesi/mapreduce/common"
+	"github.com/giulioborghesi/mapreduce/formats"
+)
 
 // RequestContext holds the parameters needed to execute a Mapper / Reducer RPC
 // call. Idx is the task number within its group, while Cnt is the number of
-// producer / consumer, depending on the context
+// producer / consumer, depending on the context. JobID identifies the job the
+// task belongs to and Program the name of the program the task executes, or
+// Streaming the commands it executes for streaming jobs and Wasm the hash of
+// the module implementing the map and reduce functions of WebAssembly jobs.
+// Cache lists the side files of the job, served by the master at CacheURL
+// together with the module of the job, if any. Split is the
+// input split of a Mapper task, read using the input format named
+// InputFormat, and Source the tag of its records, if any, while Output is
+// the directory where a Reducer task writes its output using the output
+// format named OutputFormat. NamedOutputs maps the named outputs of the job
+// to their output format. TaskID identifies the task among the tasks of the
+// master, while AttemptID uniquely identifies the task attempt and can be
+// used to cancel it
 type RequestContext struct {
 	Idx                   int
 	MapperCnt, ReducerCnt int
-	File                  string
+	JobID                 string
+	Program               string
+	Streaming             *Streaming
+	Wasm                  string
+	Cache                 []CacheFile
+	CacheURL              string
+	InputFormat           string
+	Source                string
+	Split                 formats.Split
+	Output                string
+	OutputFormat          string
+	NamedOutputs          map[string]string
+	TaskID                int32
+	AttemptID             string
 }
 
 // UpdateRequestContext holds the parameters needed to update the mapper task /
-// worker address with latest information received from the master
+// worker address with latest information received from the master for a job.
+// Hosts only contains the changes between versions Since and Version of the
+// data sources
 type UpdateRequestContext struct {
-	File  string
-	Hosts map[int]common.Host
+	JobID          string
+	Since, Version int64
+	Hosts          map[int]common.Host
+}
+
+// UpdateReply holds the version of the data sources known to a worker after
+// an update request has been processed
+type UpdateReply struct {
+	Version int64
 }
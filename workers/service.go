@@ -1,40 +1,160 @@
 package workers
 
 import (
-	"fmt"
 	"sync"
+	"time"
 
 	"github.com/giulioborghesi/mapreduce/common"
+	"github.com/giulioborghesi/mapreduce/roles"
 )
 
 // Void is a dummy type used for empty RPC arguments
 type Void struct{}
 
+// Config holds the configuration parameters of a MapReduce service
+type Config struct {
+	// ShuffleMemoryBytes is the amount of memory a reduce task can use to
+	// store the fetched map outputs before merging them to disk
+	ShuffleMemoryBytes int64
+	// SortMemoryBytes is the amount of memory a map task can use to buffer
+	// its output before sorting it and spilling it to disk
+	SortMemoryBytes int64
+	// MapSlots and ReduceSlots are the number of Map and Reduce tasks that
+	// the worker can execute concurrently
+	MapSlots, ReduceSlots int
+	// WasmMemoryBytes is the amount of memory an instance of a WebAssembly
+	// module can use
+	WasmMemoryBytes int64
+	// Log configures the logs of the task attempts
+	Log common.LogConfig
+	// Address is the address of the worker, added to the records of the
+	// logs of the task attempts
+	Address string
+	// LogRetention is how long the logs of the task attempts of a job are
+	// kept once the job is released. Logs are never removed if zero
+	LogRetention time.Duration
+}
+
 // MapReduceService implements a MapReduce RPC service
 type MapReduceService struct {
-	tsk2host map[string]map[int]common.Host
-	mu       sync.Mutex
+	cfg       Config
+	attempts  map[string]*attempt
+	cancelled map[string]time.Time
+	tsk2host  map[string]map[int]common.Host
+	versions  map[string]int64
+	updated   chan Void
+	wasm      *wasmRuntime
+	caches    map[string]*jobCache
+	cacheRefs map[string]int
+	released  map[string]time.Time
+	mu        sync.Mutex
 }
 
 // MakeMapReduceService creates, initializes and return an instance of a
-// MapReduce service
-func MakeMapReduceService() *MapReduceService {
+// MapReduce service. The expired logs left by previous runs of the worker
+// are removed in the background
+func MakeMapReduceService(cfg Config) *MapReduceService {
 	srvc := new(MapReduceService)
+	srvc.cfg = cfg
+	srvc.attempts = make(map[string]*attempt)
+	srvc.cancelled = make(map[string]time.Time)
 	srvc.tsk2host = make(map[string]map[int]common.Host)
+	srvc.versions = make(map[string]int64)
+	srvc.updated = make(chan Void)
+	srvc.caches = make(map[string]*jobCache)
+	srvc.cacheRefs = make(map[string]int)
+	srvc.released = make(map[string]time.Time)
+	if cfg.LogRetention > 0 {
+		go removeExpiredLogs(logsPath, cfg.LogRetention)
+	}
 	return srvc
 }
 
+// taskProgram returns the program executed by a task attempt, and a function
+// releasing the resources of the program. The program of streaming and
+// WebAssembly jobs only provides the partitioner and the key order: keys are
+// hash partitioned and sorted in lexicographic order unless the job
+// specifies a program. The map and reduce functions of WebAssembly jobs are
+// executed by an instance of the job module. The side files of the job are
+// fetched before the program is created, and passed to its Setup function
+// together with the named outputs of the attempt
+func (srvc *MapReduceService) taskProgram(ctx *RequestContext, a *attempt,
+	named *namedOutputs) (roles.Program, func(), error) {
+	cache, err := srvc.jobCache(a, ctx)
+	if err != nil {
+		return roles.Program{}, nil, &userError{err}
+	}
+
+	prog := roles.Program{Partition: roles.HashPartition}
+	if ctx.Program != "" || (ctx.Streaming == nil && ctx.Wasm == "") {
+		p, err := roles.Lookup(ctx.Program)
+		if err != nil {
+			return roles.Program{}, nil, err
+		}
+		prog = p
+	}
+	if prog.Setup != nil {
+		p, err := prog.Setup(taskResources{jobCache: cache,
+			namedOutputs: named})
+		if err != nil {
+			return roles.Program{}, nil, &userError{err}
+		}
+		if p.Partition == nil {
+			p.Partition = prog.Partition
+		}
+		prog = p
+	}
+	if ctx.Wasm == "" {
+		return prog, func() {}, nil
+	}
+
+	w, err := srvc.wasmRuntime()
+	if err != nil {
+		return roles.Program{}, nil, err
+	}
+	return w.program(a, ctx, prog)
+}
+
+// taskResources implements the resources of a task passed to the Setup
+// function of a program
+type taskResources struct {
+	*jobCache
+	*namedOutputs
+}
+
+// wasmRuntime returns the runtime executing WebAssembly modules, creating it
+// the first time it is needed
+func (srvc *MapReduceService) wasmRuntime() (*wasmRuntime, error) {
+	srvc.mu.Lock()
+	defer srvc.mu.Unlock()
+
+	if srvc.wasm == nil {
+		w, err := makeWasmRuntime(srvc.cfg.WasmMemoryBytes)
+		if err != nil {
+			return nil, err
+		}
+		srvc.wasm = w
+	}
+	return srvc.wasm, nil
+}
+
 // host returns the host information for a mapper task with specified index
-// that processed a specified file
-func (srvc *MapReduceService) host(file string, idx int) common.Host {
+// that belongs to a specified job. An empty host is returned if the output of
+// the mapper task is not available yet
+func (srvc *MapReduceService) host(jobID string, idx int) common.Host {
 	srvc.mu.Lock()
 	defer srvc.mu.Unlock()
 
-	if _, ok := srvc.tsk2host[file]; !ok {
+	if _, ok := srvc.tsk2host[jobID]; !ok {
 		return common.Host("")
 	}
-	if _, ok := srvc.tsk2host[file][idx]; !ok {
-		panic(fmt.Sprintf("host: invalid task idx: %d", idx))
-	}
-	return srvc.tsk2host[file][idx]
+	return srvc.tsk2host[jobID][idx]
+}
+
+// sourcesUpdated returns a channel that is closed the next time the data
+// sources are updated
+func (srvc *MapReduceService) sourcesUpdated() <-chan Void {
+	srvc.mu.Lock()
+	defer srvc.mu.Unlock()
+	return srvc.updated
 }
@@ -0,0 +1,220 @@
+package workers
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+const (
+	// segmentFraction is the fraction of the shuffle memory budget that a
+	// single map output can use before being written directly to disk
+	segmentFraction = 4
+	// maxRuns is the maximum number of on-disk runs kept before they are
+	// merged into a single run
+	maxRuns = 16
+)
+
+// shuffleMerger collects the sorted map outputs fetched by a reduce task.
+// Small outputs are kept in memory until the memory budget is exhausted, at
+// which point they are merged into a sorted run stored on disk. Outputs too
+// large to fit in memory are written to disk directly
+type shuffleMerger struct {
+	budget   int64
+	order    utils.KeyOrder
+	used     int64
+	segments [][]byte
+	runs     []string
+	prefix   string
+	runCnt   int
+}
+
+// makeShuffleMerger creates a new shuffleMerger object with the specified
+// memory budget. Map outputs are sorted according to the specified key
+// order, and on-disk runs are stored in files whose name starts with prefix
+func makeShuffleMerger(budget int64, order utils.KeyOrder,
+	prefix string) *shuffleMerger {
+	return &shuffleMerger{budget: budget, order: order, prefix: prefix}
+}
+
+// add reads a sorted map output from r. The size of the output is used to
+// decide whether the output should be kept in memory; a negative size means
+// that the size is not known in advance. On error, no data from r is retained
+func (m *shuffleMerger) add(r io.Reader, size int64) error {
+	limit := m.budget / segmentFraction
+	if size > limit {
+		return m.addToDisk(r, nil)
+	}
+
+	// Read output into memory. Fall back to disk if it turns out to be larger
+	// than expected
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(r, limit+1)); err != nil {
+		return err
+	}
+	if int64(buf.Len()) > limit {
+		return m.addToDisk(r, buf.Bytes())
+	}
+
+	// Merge in-memory outputs to disk if the budget would be exceeded
+	if m.used+int64(buf.Len()) > m.budget {
+		if err := m.spill(); err != nil {
+			return err
+		}
+	}
+	m.segments = append(m.segments, buf.Bytes())
+	m.used += int64(buf.Len())
+	return nil
+}
+
+// addToDisk writes a map output to disk as a new run. The data already read
+// from the output, if any, is passed in head
+func (m *shuffleMerger) addToDisk(r io.Reader, head []byte) error {
+	return m.writeRun(func(w io.Writer) error {
+		if _, err := w.Write(head); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// spill merges the in-memory outputs into a sorted run stored on disk and
+// releases the memory used by them
+func (m *shuffleMerger) spill() error {
+	if len(m.segments) == 0 {
+		return nil
+	}
+
+	err := m.writeRun(func(w io.Writer) error {
+		return utils.MergeKeyValues(w, m.order, m.readers()...)
+	})
+	if err != nil {
+		return err
+	}
+	m.segments = nil
+	m.used = 0
+	return nil
+}
+
+// writeRun creates a new on-disk run whose content is generated by write. If
+// too many runs exist afterwards, the runs are merged into a single one
+func (m *shuffleMerger) writeRun(write func(io.Writer) error) error {
+	path := m.prefix + ".run." + strconv.Itoa(m.runCnt)
+	m.runCnt++
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	m.runs = append(m.runs, path)
+
+	if len(m.runs) <= maxRuns {
+		return nil
+	}
+	return m.mergeRuns()
+}
+
+// mergeRuns merges all on-disk runs into a single run
+func (m *shuffleMerger) mergeRuns() error {
+	runs := m.runs
+	fs, err := openFiles(runs)
+	if err != nil {
+		return err
+	}
+	defer closeFiles(fs)
+
+	m.runs = nil
+	err = m.writeRun(func(w io.Writer) error {
+		rs := make([]io.Reader, 0, len(fs))
+		for _, f := range fs {
+			rs = append(rs, f)
+		}
+		return utils.MergeKeyValues(w, m.order, rs...)
+	})
+	if err != nil {
+		m.runs = runs
+		return err
+	}
+
+	for _, path := range runs {
+		os.Remove(path)
+	}
+	return nil
+}
+
+// readers returns a reader for each in-memory output
+func (m *shuffleMerger) readers() []io.Reader {
+	rs := make([]io.Reader, 0, len(m.segments))
+	for _, segment := range m.segments {
+		rs = append(rs, bytes.NewReader(segment))
+	}
+	return rs
+}
+
+// iterator returns an iterator over the merged key-value pairs stored in
+// memory and on disk. The caller is responsible for closing the returned
+// files once the iterator is no longer needed
+func (m *shuffleMerger) iterator() (*utils.KeyValueIterator, []*os.File,
+	error) {
+	fs, err := openFiles(m.runs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rs := m.readers()
+	for _, f := range fs {
+		rs = append(rs, f)
+	}
+
+	kvIt, err := utils.MakeKeyValueIterator(m.order, rs...)
+	if err != nil {
+		closeFiles(fs)
+		return nil, nil, err
+	}
+	return kvIt, fs, nil
+}
+
+// close releases the memory used by the merger and removes the on-disk runs
+func (m *shuffleMerger) close() {
+	for _, path := range m.runs {
+		os.Remove(path)
+	}
+	m.runs = nil
+	m.segments = nil
+	m.used = 0
+}
+
+// openFiles opens the files at the specified paths. On error, the files
+// opened so far are closed
+func openFiles(paths []string) ([]*os.File, error) {
+	fs := make([]*os.File, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			closeFiles(fs)
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// closeFiles closes the specified files
+func closeFiles(fs []*os.File) {
+	for _, f := range fs {
+		f.Close()
+	}
+}
@@ -10,3 +10,14 @@ const (
 // Status indicates whether an RPC call to one of the endpoints of the
 // MapReduce service was successful or not
 type Status int8
+
+// TaskReply holds the outcome of a Map / Reduce RPC call, together with the
+// final progress counters of the task attempt. Error describes why the
+// attempt failed, if known, and LogTail holds the last lines of the log of
+// the failed attempt
+type TaskReply struct {
+	Status   Status
+	Counters Progress
+	Error    string
+	LogTail  string
+}
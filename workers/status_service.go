@@ -5,3 +5,16 @@ package workers
 func (srvc *MapReduceService) Status(_ Void, _ *Void) error {
 	return nil
 }
+
+// WorkerInfo describes the resources a worker offers to the master
+type WorkerInfo struct {
+	MapSlots, ReduceSlots int
+}
+
+// Register is a RPC endpoint used by the master to retrieve the number of Map
+// and Reduce tasks a worker can execute concurrently
+func (srvc *MapReduceService) Register(_ Void, info *WorkerInfo) error {
+	info.MapSlots = srvc.cfg.MapSlots
+	info.ReduceSlots = srvc.cfg.ReduceSlots
+	return nil
+}
@@ -0,0 +1,246 @@
+package workers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/roles"
+	"github.com/giulioborghesi/mapreduce/utils"
+)
+
+const (
+	// stderrTail is the number of bytes at the end of the standard error of
+	// a streaming command reported when the command fails
+	stderrTail = 2048
+	// pipesWait is how long the pipes of a killed streaming command are kept
+	// open for its output to be drained
+	pipesWait = time.Second
+)
+
+// Streaming describes the external commands executed by the tasks of a
+// streaming job. Commands are run by the shell; they read records from their
+// standard input and write key / value pairs to their standard output, one
+// per line, with keys separated from values by a tab. Map commands receive
+// the lines of text inputs, and tab separated key / value pairs for other
+// input formats. Reduce commands receive the tab separated intermediate key /
+// value pairs sorted by key. Timeout bounds the running time of a command,
+// unless zero
+type Streaming struct {
+	Mapper, Reducer string
+	Timeout         time.Duration
+}
+
+// userError is an error raised by user code. User errors fail the task
+// attempt, but do not affect the worker
+type userError struct {
+	err error
+}
+
+// Error returns the description of the error
+func (e *userError) Error() string {
+	return e.err.Error()
+}
+
+// failAttempt marks an attempt as failed if err is a user error, and returns
+// err otherwise
+func failAttempt(r *TaskReply, err error) error {
+	var ue *userError
+	if errors.As(err, &ue) {
+		r.Status = FAILED
+		r.Error = ue.Error()
+		return nil
+	}
+	return err
+}
+
+// stderrLogger writes the lines written to the standard error of a streaming
+// command or of a WebAssembly module to the log of the attempt, and keeps the
+// last bytes written
+type stderrLogger struct {
+	log  *slog.Logger
+	line []byte
+	tail []byte
+}
+
+// Write logs the complete lines written so far
+func (l *stderrLogger) Write(data []byte) (int, error) {
+	l.tail = append(l.tail, data...)
+	if len(l.tail) > stderrTail {
+		l.tail = l.tail[len(l.tail)-stderrTail:]
+	}
+
+	l.line = append(l.line, data...)
+	for {
+		idx := bytes.IndexByte(l.line, '\n')
+		if idx < 0 {
+			break
+		}
+		l.log.Info("stderr", "line", string(l.line[:idx]))
+		l.line = l.line[idx+1:]
+	}
+	return len(data), nil
+}
+
+// runStreaming runs a streaming command. The standard input of the command
+// is written by feed, while the key / value pairs written by the command to
+// its standard output are passed to out. The command is killed if the
+// attempt is cancelled or the timeout expires. Failures of the command are
+// reported as user errors
+func runStreaming(a *attempt, command string,
+	timeout time.Duration, feed func(w *bufio.Writer) error,
+	out roles.Emitter[string, string]) error {
+	ctx, cancel := a.ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(a.ctx, timeout)
+	}
+	defer cancel()
+
+	// Run the command in its own process group, so that the processes it
+	// starts are killed together with it
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = pipesWait
+	stderr := &stderrLogger{log: a.logger()}
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return &userError{fmt.Errorf("runstreaming: %v", err)}
+	}
+
+	// Write the standard input of the command asynchronously
+	fed := make(chan error, 1)
+	go func() {
+		w := bufio.NewWriter(stdin)
+		err := feed(w)
+		if err == nil {
+			err = w.Flush()
+		}
+		stdin.Close()
+		fed <- err
+	}()
+
+	// Parse the standard output of the command
+	parseErr := parseStreamingOutput(stdout, out)
+	if parseErr != nil {
+		cmd.Cancel()
+		io.Copy(io.Discard, stdout)
+	}
+	waitErr := cmd.Wait()
+	feedErr := <-fed
+	if errors.Is(feedErr, syscall.EPIPE) || errors.Is(feedErr, os.ErrClosed) {
+		feedErr = nil
+	}
+
+	switch {
+	case a.ctx.Err() != nil:
+		return a.ctx.Err()
+	case ctx.Err() != nil:
+		return &userError{fmt.Errorf("runstreaming: command timed out "+
+			"after %v", timeout)}
+	case waitErr != nil:
+		return &userError{fmt.Errorf("runstreaming: command failed: %v: %s",
+			waitErr, bytes.TrimSpace(stderr.tail))}
+	case parseErr != nil:
+		return parseErr
+	}
+	return feedErr
+}
+
+// parseStreamingOutput parses the key / value pairs written by a streaming
+// command and passes them to out. Lines without a tab are keys with an
+// empty value
+func parseStreamingOutput(r io.Reader, out roles.Emitter[string,
+	string]) error {
+	reader := bufio.NewReader(r)
+	for {
+		l, err := reader.ReadString('\n')
+		if err == io.EOF && len(l) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		key, value, _ := strings.Cut(strings.TrimSuffix(l, "\n"), "\t")
+		if err := out.Emit(key, value); err != nil {
+			return err
+		}
+	}
+}
+
+// streamMap runs the map command of a streaming job on the records read by
+// rr and passes the key / value pairs it writes to out
+func streamMap(ctx *RequestContext, a *attempt, rr formats.RecordReader,
+	out roles.Emitter[string, string]) error {
+	valuesOnly := ctx.InputFormat == "" ||
+		ctx.InputFormat == formats.TextFormat
+	return runStreaming(a, ctx.Streaming.Mapper, ctx.Streaming.Timeout,
+		func(w *bufio.Writer) error {
+			for a.ctx.Err() == nil {
+				rec, err := rr.Next()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+
+				line := rec.Key + "\t" + rec.Value + "\n"
+				if valuesOnly {
+					line = rec.Value + "\n"
+				}
+				if _, err := w.WriteString(line); err != nil {
+					return err
+				}
+				a.bytesRead.Store(rr.BytesRead())
+				a.records.Add(1)
+			}
+			return nil
+		}, out)
+}
+
+// streamReduce runs the reduce command of a streaming job on the sorted
+// intermediate key / value pairs and passes the key / value pairs it writes
+// to out
+func streamReduce(ctx *RequestContext, a *attempt,
+	kvIt *utils.KeyValueIterator, out roles.Emitter[string, string]) error {
+	return runStreaming(a, ctx.Streaming.Reducer, ctx.Streaming.Timeout,
+		func(w *bufio.Writer) error {
+			for kvIt.HasNext() && a.ctx.Err() == nil {
+				_, vIt := kvIt.Next()
+				for vIt.HasNext() {
+					value, err := vIt.Next()
+					if err != nil {
+						return err
+					}
+					_, err = w.WriteString(vIt.ValueKey() + "\t" + value + "\n")
+					if err != nil {
+						return err
+					}
+				}
+				a.keys.Add(1)
+			}
+			return nil
+		}, out)
+}
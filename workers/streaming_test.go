@@ -0,0 +1,46 @@
+package workers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/giulioborghesi/mapreduce/roles"
+)
+
+func TestRunStreaming(t *testing.T) {
+	a := new(attempt)
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	defer a.cancel()
+
+	feed := func(w *bufio.Writer) error {
+		_, err := w.WriteString("b\t2\na\t1\nc\n")
+		return err
+	}
+	run := func(command string, timeout time.Duration) ([]string, error) {
+		res := make([]string, 0)
+		out := roles.EmitterFunc[string, string](func(k, v string) error {
+			res = append(res, k+"="+v)
+			return nil
+		})
+		return res, runStreaming(a, command, timeout, feed, out)
+	}
+
+	res, err := run("sort", 0)
+	expected := []string{"a=1", "b=2", "c="}
+	if err != nil || !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected: %v, actual: %v, error: %v", expected, res, err)
+	}
+
+	var ue *userError
+	if _, err := run("cat; exit 3", 0); !errors.As(err, &ue) {
+		t.Errorf("expected user error for failed command, actual: %v", err)
+	}
+	if _, err := run("sleep 5", 50*time.Millisecond); !errors.As(err, &ue) {
+		t.Errorf("expected user error for timed out command, actual: %v",
+			err)
+	}
+}
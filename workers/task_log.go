@@ -0,0 +1,153 @@
+package workers
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/giulioborghesi/mapreduce/common"
+)
+
+const (
+	logsPath = "/Users/giulioborghesi/tmp/logs/"
+	// logTailLines is the number of lines at the end of the log of a failed
+	// attempt reported to the master
+	logTailLines = 20
+	// logTailBytes bounds the number of bytes read from the end of a log to
+	// find its last lines
+	logTailBytes = 16 << 10
+)
+
+// taskLogPath returns the path of the log of a task attempt
+func taskLogPath(jobID, attemptID string) string {
+	return filepath.Join(logsPath, jobID, attemptID+".log")
+}
+
+// openTaskLog creates the log of a task attempt, configured by cfg. Records
+// carry the job, task and attempt IDs and the address of the worker, and are
+// written to the default logger if the log cannot be created
+func openTaskLog(cfg common.LogConfig, worker string,
+	ctx *RequestContext) (*slog.Logger, *os.File) {
+	attrs := []any{"job", ctx.JobID, "task", ctx.TaskID, "attempt",
+		ctx.AttemptID, "worker", worker}
+	path := taskLogPath(ctx.JobID, ctx.AttemptID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		slog.Warn("cannot create task log", "path", path, "error", err)
+		return slog.With(attrs...), nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		slog.Warn("cannot create task log", "path", path, "error", err)
+		return slog.With(attrs...), nil
+	}
+	return slog.New(cfg.Handler(f)).With(attrs...), f
+}
+
+// removeJobLogs removes the logs of the task attempts of a job
+func removeJobLogs(jobID string) {
+	path := filepath.Join(logsPath, jobID)
+	if err := os.RemoveAll(path); err != nil {
+		slog.Warn("cannot remove task logs", "path", path, "error", err)
+	}
+}
+
+// removeExpiredLogs removes the log directories of the jobs under dir that
+// have not been modified for longer than retention. It is run when the
+// worker starts, to remove the logs of the jobs run before the worker was
+// restarted, which are never released
+func removeExpiredLogs(dir string, retention time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() ||
+			time.Since(info.ModTime()) < retention {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			slog.Warn("cannot remove task logs", "path", path, "error", err)
+		}
+	}
+}
+
+// logTail returns at most n lines at the end of the log at path
+func logTail(path string, n int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := max(info.Size()-logTailBytes, 0)
+	data := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	// Drop the partial first line, unless the whole log has been read, and
+	// keep the last n lines
+	data = bytes.TrimRight(data, "\n")
+	if offset > 0 {
+		if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+			data = data[idx+1:]
+		}
+	}
+	lines := strings.Split(string(data), "\n")
+	return strings.Join(lines[max(len(lines)-n, 0):], "\n"), nil
+}
+
+// SendLog serves the log of a task attempt, at /logs/{job ID}/{attempt ID}.
+// Only the last lines of the log are sent if the tail query parameter is set
+func SendLog(w http.ResponseWriter, r *http.Request) {
+	jobID, attemptID, ok := strings.Cut(strings.TrimPrefix(r.URL.Path,
+		"/logs/"), "/")
+	if !ok || !validLogName(jobID) || !validLogName(attemptID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if s := r.URL.Query().Get("tail"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid tail: "+s, http.StatusBadRequest)
+			return
+		}
+		tail, err := logTail(taskLogPath(jobID, attemptID), n)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, tail+"\n")
+		return
+	}
+
+	f, err := os.Open(taskLogPath(jobID, attemptID))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	io.Copy(w, f)
+}
+
+// validLogName returns true if name can be used as a path component of a
+// task log
+func validLogName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,115 @@
+package workers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giulioborghesi/mapreduce/common"
+)
+
+func TestLogTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "attempt_0_1.log")
+	var lines []string
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	data := strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tail, err := logTail(path, 3)
+	if err != nil || tail != "line 4997\nline 4998\nline 4999" {
+		t.Errorf("unexpected tail: %q, %v", tail, err)
+	}
+
+	// The first lines of the log are all returned if the log is short
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tail, err := logTail(path, 3); err != nil || tail != "a\nb" {
+		t.Errorf("unexpected tail: %q, %v", tail, err)
+	}
+
+	for _, name := range []string{"", ".", "..", "a/b"} {
+		if validLogName(name) {
+			t.Errorf("expected %q to be an invalid log name", name)
+		}
+	}
+}
+
+func TestRemoveExpiredLogs(t *testing.T) {
+	dir := t.TempDir()
+	for _, job := range []string{"job_1_0001", "job_2_0001"} {
+		if err := os.MkdirAll(filepath.Join(dir, job), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "job_1_0001"), old,
+		old); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Only the logs older than the retention period are removed
+	removeExpiredLogs(dir, time.Hour)
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "job_2_0001" {
+		t.Errorf("unexpected log directories: %v", entries)
+	}
+}
+
+func TestOpenTaskLog(t *testing.T) {
+	ctx := &RequestContext{JobID: "job_log_test", TaskID: 7,
+		AttemptID: "attempt_7_1"}
+	defer os.RemoveAll(filepath.Dir(taskLogPath(ctx.JobID, ctx.AttemptID)))
+
+	// Records carry the job, the task, the attempt and the worker
+	log, f := openTaskLog(common.LogConfig{}, "localhost:1234", ctx)
+	if f == nil {
+		t.Skipf("cannot create task log")
+	}
+	log.Info("started")
+	f.Close()
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, attr := range []string{"job=job_log_test", "task=7",
+		"attempt=attempt_7_1", "worker=localhost:1234"} {
+		if !strings.Contains(string(data), attr) {
+			t.Errorf("expected %s in log record: %q", attr, data)
+		}
+	}
+}
+
+func TestReleaseJobLogs(t *testing.T) {
+	// The retention is set after the service is created, so that the logs
+	// of other jobs are not swept
+	srvc := MakeMapReduceService(Config{})
+	srvc.cfg.LogRetention = 200 * time.Millisecond
+	path := filepath.Dir(taskLogPath("job_release_test", "attempt_0_1"))
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Skipf("cannot create task logs: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	// The logs of a job are removed once the retention period has elapsed
+	// since its release
+	srvc.ReleaseJob("job_release_test", nil)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("logs removed before the retention period: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("logs not removed after the retention period")
+}
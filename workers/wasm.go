@@ -0,0 +1,418 @@
+package workers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/roles"
+	"github.com/giulioborghesi/mapreduce/utils"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const (
+	// wasmPageSize is the size of a WebAssembly memory page
+	wasmPageSize = 1 << 16
+	// wasmMaxPages is the maximum number of pages of a WebAssembly memory
+	wasmMaxPages = 1 << 16
+)
+
+// WasmHash returns the hash identifying a WebAssembly module, that is the hex
+// encoded SHA-256 digest of its code
+func WasmHash(code []byte) string {
+	sum := sha256.Sum256(code)
+	return hex.EncodeToString(sum[:])
+}
+
+// WasmFileName returns the name under which the master serves the module
+// with the specified hash, next to the side files of the job
+func WasmFileName(hash string) string {
+	return hash + ".wasm"
+}
+
+// ValidateWasmModule checks that the code of a WebAssembly module is valid
+// and that the module exports the functions needed by a job, reduce included
+// if the job has Reducer tasks.
+//
+// Modules export their memory and the functions alloc(size) -> ptr, which
+// returns a guest buffer of size bytes, map(keyPtr, keyLen, valuePtr,
+// valueLen), which maps an input record, and reduce(keyPtr, keyLen), which
+// reduces a group of intermediate keys; dealloc(ptr, size) is called to
+// release the buffers returned by alloc, if exported. Modules emit key /
+// value pairs by calling emit(keyPtr, keyLen, valuePtr, valueLen), imported
+// from the env module, and reduce reads the values of the group by calling
+// next_value(ptr, cap) until it returns -1. next_value copies the next value
+// to the buffer and returns its length; if the value is larger than cap, it
+// only returns its length, and the value is returned again by the next call.
+// Modules can import WASI, but have no access to the filesystem, the network
+// or the environment of the worker. Their standard error is written to the
+// log of the task attempt
+func ValidateWasmModule(code []byte, reduce bool) error {
+	ctx := context.Background()
+	r := wazero.NewRuntime(ctx)
+	defer r.Close(ctx)
+
+	compiled, err := r.CompileModule(ctx, code)
+	if err != nil {
+		return fmt.Errorf("validatewasmmodule: %v", err)
+	}
+	return checkWasmExports(compiled, reduce)
+}
+
+// checkWasmExports checks that a compiled module exports the functions needed
+// by a job
+func checkWasmExports(compiled wazero.CompiledModule, reduce bool) error {
+	names := []string{"alloc", "map"}
+	if reduce {
+		names = append(names, "reduce")
+	}
+
+	exported := compiled.ExportedFunctions()
+	for _, name := range names {
+		if _, ok := exported[name]; !ok {
+			return fmt.Errorf("checkwasmexports: module does not export %s",
+				name)
+		}
+	}
+	if len(compiled.ExportedMemories()) == 0 {
+		return fmt.Errorf("checkwasmexports: module does not export its " +
+			"memory")
+	}
+	return nil
+}
+
+// wasmRuntime executes WebAssembly modules. Modules are fetched from the
+// master and compiled once, cached by hash, and the memory of module
+// instances is bounded. Instances are closed as soon as the context of the
+// call they are executing is done. The hash of the module of each job is
+// tracked, so that compiled modules are freed when the last job using them
+// is released
+type wasmRuntime struct {
+	r        wazero.Runtime
+	compiled map[string]*compiledModule
+	jobs     map[string]string
+	sync.Mutex
+}
+
+// compiledModule is a module of the cache of a runtime. The module is fetched
+// and compiled by the first attempt needing it, without holding the runtime
+// lock; done is closed once the module is compiled, or has failed to
+type compiledModule struct {
+	done     chan struct{}
+	compiled wazero.CompiledModule
+	err      error
+}
+
+// makeWasmRuntime creates a runtime whose module instances use at most
+// memoryBytes bytes of memory
+func makeWasmRuntime(memoryBytes int64) (*wasmRuntime, error) {
+	pages := memoryBytes / wasmPageSize
+	if pages < 1 {
+		pages = 1
+	} else if pages > wasmMaxPages {
+		pages = wasmMaxPages
+	}
+
+	ctx := context.Background()
+	cfg := wazero.NewRuntimeConfig().WithMemoryLimitPages(uint32(pages)).
+		WithCloseOnContextDone(true)
+	r := wazero.NewRuntimeWithConfig(ctx, cfg)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+
+	_, err := r.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(wasmEmit).Export("emit").
+		NewFunctionBuilder().WithFunc(wasmNextValue).Export("next_value").
+		Instantiate(ctx)
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	return &wasmRuntime{r: r,
+		compiled: make(map[string]*compiledModule),
+		jobs:     make(map[string]string)}, nil
+}
+
+// compile returns the compiled code of the module of a job, fetching and
+// compiling the module if it is not in the cache yet. Attempts needing a
+// module being compiled by another attempt wait for it, and modules that
+// cannot be compiled are removed from the cache
+func (w *wasmRuntime) compile(a *attempt,
+	ctx *RequestContext) (wazero.CompiledModule, error) {
+	w.Lock()
+	w.jobs[ctx.JobID] = ctx.Wasm
+	m, ok := w.compiled[ctx.Wasm]
+	if !ok {
+		m = &compiledModule{done: make(chan struct{})}
+		w.compiled[ctx.Wasm] = m
+	}
+	w.Unlock()
+
+	if !ok {
+		m.compiled, m.err = w.load(a, ctx)
+		if m.err != nil {
+			w.Lock()
+			if w.compiled[ctx.Wasm] == m {
+				delete(w.compiled, ctx.Wasm)
+			}
+			w.Unlock()
+		}
+		close(m.done)
+	}
+
+	select {
+	case <-m.done:
+		return m.compiled, m.err
+	case <-a.ctx.Done():
+		return nil, a.ctx.Err()
+	}
+}
+
+// load fetches the module of a job from the master and compiles it
+func (w *wasmRuntime) load(a *attempt,
+	ctx *RequestContext) (wazero.CompiledModule, error) {
+	code, err := fetchWasmModule(a, ctx.CacheURL, ctx.Wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := w.r.CompileModule(context.Background(), code)
+	if err != nil {
+		return nil, fmt.Errorf("compile: %v", err)
+	}
+	if err := checkWasmExports(compiled, false); err != nil {
+		compiled.Close(context.Background())
+		return nil, err
+	}
+	return compiled, nil
+}
+
+// release forgets the module of a job, and frees its compiled code unless
+// the module is also used by other jobs. Modules being compiled are freed
+// once compiled
+func (w *wasmRuntime) release(jobID string) {
+	w.Lock()
+	defer w.Unlock()
+
+	hash, ok := w.jobs[jobID]
+	if !ok {
+		return
+	}
+	delete(w.jobs, jobID)
+	for _, h := range w.jobs {
+		if h == hash {
+			return
+		}
+	}
+	m, ok := w.compiled[hash]
+	if !ok {
+		return
+	}
+	delete(w.compiled, hash)
+	go func() {
+		<-m.done
+		if m.compiled != nil {
+			m.compiled.Close(context.Background())
+		}
+	}()
+}
+
+// fetchWasmModule downloads the code of the module with the specified hash
+// from the master and checks its hash
+func fetchWasmModule(a *attempt, baseURL, hash string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(a.ctx, http.MethodGet,
+		baseURL+"/"+WasmFileName(hash), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetchwasmmodule: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetchwasmmodule: cannot fetch module %s: %s",
+			hash, resp.Status)
+	}
+	code, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetchwasmmodule: %v", err)
+	}
+	if WasmHash(code) != hash {
+		return nil, fmt.Errorf("fetchwasmmodule: hash mismatch for module %s",
+			hash)
+	}
+	return code, nil
+}
+
+// program instantiates the module of a job and returns a program executing
+// its map and reduce functions in the context of an attempt, with the
+// partitioner and the key order of base. The returned function releases the
+// module instance
+func (w *wasmRuntime) program(a *attempt, ctx *RequestContext,
+	base roles.Program) (roles.Program, func(), error) {
+	compiled, err := w.compile(a, ctx)
+	if err != nil {
+		return roles.Program{}, nil, &userError{err}
+	}
+
+	cfg := wazero.NewModuleConfig().WithName("").
+		WithStartFunctions("_initialize").
+		WithStderr(&stderrLogger{log: a.logger()})
+	mod, err := w.r.InstantiateModule(a.ctx, compiled, cfg)
+	if err != nil {
+		return roles.Program{}, nil, &userError{fmt.Errorf("program: %v",
+			err)}
+	}
+
+	inst := &wasmInstance{ctx: a.ctx, mod: mod,
+		alloc:    mod.ExportedFunction("alloc"),
+		dealloc:  mod.ExportedFunction("dealloc"),
+		mapFn:    mod.ExportedFunction("map"),
+		reduceFn: mod.ExportedFunction("reduce")}
+	base.Map, base.Reduce, base.Format = inst.mapRecord, nil, nil
+	if inst.reduceFn != nil {
+		base.Reduce = inst.reduceKey
+	}
+	return base, func() { mod.Close(context.Background()) }, nil
+}
+
+// wasmInstance is an instance of a WebAssembly module executing the map and
+// reduce functions of a task attempt
+type wasmInstance struct {
+	ctx                             context.Context
+	mod                             api.Module
+	alloc, dealloc, mapFn, reduceFn api.Function
+}
+
+// wasmCallKey is the key of the state of a call in the call context
+type wasmCallKey struct{}
+
+// wasmCall holds the state of a call to the map or reduce function of a
+// module: the emitter receiving the emitted pairs and, for reduce, the values
+// of the group and the value returned to the module next, if any
+type wasmCall struct {
+	out     roles.Emitter[string, string]
+	values  *utils.ValueIterator
+	pending *string
+}
+
+// mapRecord calls the map function of the module on an input record
+func (inst *wasmInstance) mapRecord(rec formats.Record,
+	out roles.Emitter[string, string]) error {
+	return inst.call(inst.mapFn, &wasmCall{out: out}, rec.Key, rec.Value)
+}
+
+// reduceKey calls the reduce function of the module on a group of keys
+func (inst *wasmInstance) reduceKey(key string, it *utils.ValueIterator,
+	out roles.Emitter[string, string]) error {
+	return inst.call(inst.reduceFn, &wasmCall{out: out, values: it}, key)
+}
+
+// call copies the arguments to the module memory and calls fn with their
+// addresses and lengths. Errors are user errors, since they are raised by the
+// module code, unless the attempt has been cancelled
+func (inst *wasmInstance) call(fn api.Function, state *wasmCall,
+	args ...string) error {
+	ctx := context.WithValue(inst.ctx, wasmCallKey{}, state)
+	params := make([]uint64, 0, 2*len(args))
+	for _, arg := range args {
+		ptr, err := inst.write(ctx, arg)
+		if err != nil {
+			return inst.error(err)
+		}
+		if inst.dealloc != nil {
+			defer inst.dealloc.Call(ctx, uint64(ptr), uint64(len(arg)))
+		}
+		params = append(params, uint64(ptr), uint64(len(arg)))
+	}
+
+	if _, err := fn.Call(ctx, params...); err != nil {
+		return inst.error(err)
+	}
+	return nil
+}
+
+// write allocates a buffer in the module memory and copies data to it
+func (inst *wasmInstance) write(ctx context.Context, data string) (uint32,
+	error) {
+	res, err := inst.alloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+
+	ptr := uint32(res[0])
+	if !inst.mod.Memory().Write(ptr, []byte(data)) {
+		return 0, fmt.Errorf("write: buffer out of memory range")
+	}
+	return ptr, nil
+}
+
+// error wraps an error raised while executing the module code
+func (inst *wasmInstance) error(err error) error {
+	if inst.ctx.Err() != nil {
+		return inst.ctx.Err()
+	}
+	return &userError{fmt.Errorf("wasm: %v", err)}
+}
+
+// wasmRead returns a copy of a buffer of the module memory
+func wasmRead(m api.Module, ptr, size uint32) string {
+	data, ok := m.Memory().Read(ptr, size)
+	if !ok {
+		panic(fmt.Errorf("wasmread: buffer out of memory range"))
+	}
+	return string(data)
+}
+
+// wasmEmit implements the emit function imported by modules. Errors abort the
+// call to the module
+func wasmEmit(ctx context.Context, m api.Module, keyPtr, keyLen, valuePtr,
+	valueLen uint32) {
+	state := ctx.Value(wasmCallKey{}).(*wasmCall)
+	key := wasmRead(m, keyPtr, keyLen)
+	value := wasmRead(m, valuePtr, valueLen)
+	if err := state.out.Emit(key, value); err != nil {
+		panic(err)
+	}
+}
+
+// wasmNextValue implements the next_value function imported by modules
+func wasmNextValue(ctx context.Context, m api.Module, ptr, size uint32) int32 {
+	state := ctx.Value(wasmCallKey{}).(*wasmCall)
+	if state.values == nil {
+		panic(fmt.Errorf("nextvalue: no values to read"))
+	}
+
+	if state.pending == nil {
+		if !state.values.HasNext() {
+			return -1
+		}
+		value, err := state.values.Next()
+		if err != nil {
+			panic(err)
+		}
+		state.pending = &value
+	}
+
+	value := *state.pending
+	if len(value) > int(size) {
+		return int32(len(value))
+	}
+	if !m.Memory().Write(ptr, []byte(value)) {
+		panic(fmt.Errorf("nextvalue: buffer out of memory range"))
+	}
+	state.pending = nil
+	return int32(len(value))
+}
@@ -0,0 +1,161 @@
+package workers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/giulioborghesi/mapreduce/formats"
+	"github.com/giulioborghesi/mapreduce/roles"
+)
+
+// swapModule is a module whose map function emits the value of a record as
+// key and its key as value. Its alloc function is a bump allocator
+var swapModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// types: (i32, i32, i32, i32) -> () and (i32) -> i32
+	0x01, 0x0d, 0x02, 0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x00,
+	0x60, 0x01, 0x7f, 0x01, 0x7f,
+	// imports: env.emit
+	0x02, 0x0c, 0x01, 0x03, 'e', 'n', 'v', 0x04, 'e', 'm', 'i', 't',
+	0x00, 0x00,
+	// functions: alloc, map
+	0x03, 0x03, 0x02, 0x01, 0x00,
+	// memory: one page
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	// globals: mutable i32 initialized to 1024
+	0x06, 0x07, 0x01, 0x7f, 0x01, 0x41, 0x80, 0x08, 0x0b,
+	// exports: memory, alloc, map
+	0x07, 0x18, 0x03,
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x05, 'a', 'l', 'l', 'o', 'c', 0x00, 0x01,
+	0x03, 'm', 'a', 'p', 0x00, 0x02,
+	// code: alloc returns the global and increments it, map calls emit
+	0x0a, 0x1a, 0x02,
+	0x0b, 0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b,
+	0x0c, 0x00, 0x20, 0x02, 0x20, 0x03, 0x20, 0x00, 0x20, 0x01, 0x10, 0x00,
+	0x0b,
+}
+
+func TestWasmProgram(t *testing.T) {
+	if err := ValidateWasmModule(swapModule, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ValidateWasmModule(swapModule, true); err == nil {
+		t.Errorf("expected error for module without reduce function")
+	}
+
+	w, err := makeWasmRuntime(1 << 20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a := new(attempt)
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	defer a.cancel()
+
+	// The module is fetched from the master once per job
+	hash := WasmHash(swapModule)
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter,
+		r *http.Request) {
+		fetches++
+		if r.URL.Path != "/job_1/"+WasmFileName(hash) {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Write(swapModule)
+	}))
+	defer srv.Close()
+	ctx := &RequestContext{JobID: "job_1", Wasm: hash,
+		CacheURL: srv.URL + "/job_1"}
+
+	prog, release, err := w.program(a, ctx,
+		roles.Program{Partition: roles.HashPartition})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+
+	res := make([]string, 0)
+	out := roles.EmitterFunc[string, string](func(k, v string) error {
+		res = append(res, k+"="+v)
+		return nil
+	})
+	for _, rec := range []formats.Record{{Key: "a", Value: "1"},
+		{Key: "bb", Value: "22"}} {
+		if err := prog.Map(rec, out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expected := []string{"1=a", "22=bb"}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected: %v, actual: %v", expected, res)
+	}
+	if prog.Reduce != nil {
+		t.Errorf("expected no reduce function")
+	}
+
+	// Compiled modules are freed when the last job using them is released
+	if _, err := w.compile(a, ctx); err != nil || fetches != 1 {
+		t.Errorf("module fetched %d times, error: %v", fetches, err)
+	}
+	w.release("job_1")
+	if len(w.compiled) != 0 || len(w.jobs) != 0 {
+		t.Errorf("module not freed: %v", w.compiled)
+	}
+	ctx.JobID, ctx.CacheURL = "job_2", srv.URL+"/job_2"
+	if _, err := w.compile(a, ctx); err == nil {
+		t.Errorf("expected error for unknown module")
+	}
+}
+
+func TestWasmCompileConcurrent(t *testing.T) {
+	w, err := makeWasmRuntime(1 << 20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hash := WasmHash(swapModule)
+	var fetches atomic.Int32
+	hung := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Path != "/"+WasmFileName(hash) {
+			<-hung
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fetches.Add(1)
+		rw.Write(swapModule)
+	}))
+	defer srv.Close()
+	defer close(hung)
+
+	// A hung download does not block the modules of other jobs
+	a := new(attempt)
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	defer a.cancel()
+	go w.compile(a, &RequestContext{JobID: "job_0", Wasm: "hung",
+		CacheURL: srv.URL})
+
+	// Attempts compiling the same module concurrently fetch it once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := &RequestContext{JobID: "job_1", Wasm: hash,
+				CacheURL: srv.URL}
+			if _, err := w.compile(a, ctx); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("module fetched %d times, want: 1", n)
+	}
+}